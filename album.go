@@ -4,56 +4,116 @@ package dbps
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"expvar"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
-	"path"
 	"sort"
 	"sync"
 	"time"
 
-	"github.com/dpup/dbps/internal/dropbox"
 	"github.com/dpup/dbps/internal/goexif/exif"
 	"github.com/dpup/rcache"
 )
 
-// Album queries dropbox and keeps a list of photos in date order.
+// Album queries a source and keeps a list of photos in date order.
 type Album struct {
-	folder  string
-	dropbox *dropbox.Client
-	cache   rcache.Cache
+	// Timeouts for listing the source, downloading a photo, and resizing it.
+	ListTimeout     time.Duration
+	DownloadTimeout time.Duration
+	ResizeTimeout   time.Duration
+
+	// Logger receives the album's log messages, by default they're written to
+	// the standard logger.
+	Logger Logger
+
+	// AlwaysReadExif reads the exif data of photos even when the source provides
+	// their metadata, to get details of the camera and exposure. This means
+	// downloading the start of every new or changed photo.
+	AlwaysReadExif bool
+
+	name   string
+	source Source
+	cache  rcache.Cache
+	disk   *diskCache
+
+	metrics *metrics
+
+	snapshot string
+	restored bool
 
 	photoList photoList
 	photoMap  map[string]Photo
+	cursor    string
+	resets    int
 	loading   bool
+	loadErr   error
+	version   uint64
+	modified  time.Time
+	lastLoad  time.Time
+	failures  int
+	backoff   time.Duration
+	ready     chan struct{}
+	refresh   chan struct{}
 	mu        sync.RWMutex
+
+	subscribers map[int]func(Event)
+	nextSub     int
+	subMu       sync.Mutex
 }
 
-// NewAlbum returns a new Album
-func NewAlbum(folder string, dropbox *dropbox.Client) *Album {
-	a := &Album{folder: folder, dropbox: dropbox, cache: rcache.New(folder)}
+// NewAlbum returns a new Album, name should be unique within the process.
+func NewAlbum(name string, source Source) *Album {
+	a := &Album{
+		ListTimeout:     time.Minute * 2,
+		DownloadTimeout: time.Minute * 2,
+		ResizeTimeout:   time.Second * 30,
+		Logger:          stdLogger{},
+		name:            name,
+		source:          source,
+		cache:           rcache.New(name),
+		ready:           make(chan struct{}),
+		refresh:         make(chan struct{}, 1),
+		metrics:         newMetrics(),
+	}
 	a.cache.RegisterFetcher(a.fetchOriginal)
 	a.cache.RegisterFetcher(a.fetchThumbnail)
 
-	expvar.Publish(fmt.Sprintf("photos (%s)", folder), expvar.Func(func() interface{} {
+	expvar.Publish(fmt.Sprintf("photos (%s)", name), expvar.Func(func() interface{} {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
 		return a.photoMap
 	}))
 
 	return a
 }
 
+// refreshDelay is how long Monitor waits after Refresh is called before loading,
+// so that a burst of notifications results in a single Load.
+const refreshDelay = 2 * time.Second
+
 // Monitor starts a go routine which calls Load() every interval to pick up new
-// changes
+// changes, or shortly after Refresh is called.
 func (a *Album) Monitor(interval time.Duration) {
 	c := interval
 	go func() {
 		for {
-			time.Sleep(c)
+			a.setBackoff(c)
+			select {
+			case <-time.After(c):
+			case <-a.refresh:
+				time.Sleep(refreshDelay)
+				select {
+				case <-a.refresh:
+				default:
+				}
+			}
 			err := a.Load()
 			if err != nil {
-				log.Printf("album: failed to refresh after %s: %s", c, err)
+				a.Logger.Error("album: failed to refresh", "album", a.name, "after", c, "err", err)
 				c = c * 2
 			} else {
 				c = interval
@@ -62,8 +122,64 @@ func (a *Album) Monitor(interval time.Duration) {
 	}()
 }
 
-// Load fetches metadata about the photos in a folder. If the folder hasn't
-// changed since Load was last called then no work wil be done.
+// Refresh asks Monitor to Load as soon as possible, instead of waiting for the
+// rest of the interval. Calls made before the Load starts are coalesced.
+func (a *Album) Refresh() {
+	select {
+	case a.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Watch starts a go routine which waits for the source to report changes and
+// calls Load() as soon as any are. Unlike Monitor, no requests are made while
+// the album is idle. The source must implement Watcher.
+func (a *Album) Watch() {
+	w, ok := a.source.(Watcher)
+	if !ok {
+		a.Logger.Error("album: source can't be watched", "album", a.name)
+		return
+	}
+	go func() {
+		c := time.Second * 5
+		for {
+			changes, backoff, err := a.waitForChanges(w)
+			if err == nil && changes {
+				err = a.Load()
+			}
+			if err != nil {
+				a.Logger.Error("album: failed to watch for changes", "album", a.name, "retry", c, "err", err)
+				a.setBackoff(c)
+				time.Sleep(c)
+				c = c * 2
+				continue
+			}
+			c = time.Second * 5
+			a.setBackoff(backoff)
+			time.Sleep(backoff)
+		}
+	}()
+}
+
+// waitForChanges blocks until the source reports changes since the last Load.
+func (a *Album) waitForChanges(w Watcher) (bool, time.Duration, error) {
+	a.mu.RLock()
+	cursor := a.cursor
+	a.mu.RUnlock()
+
+	// Without a cursor there's nothing to wait on, so treat it as changed.
+	if cursor == "" {
+		return true, 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), watchTimeout)
+	defer cancel()
+	return w.Wait(ctx, cursor)
+}
+
+// Load fetches metadata about the photos in the source. The first call lists
+// every file, subsequent calls use the stored cursor to only apply changes
+// that have happened since. If nothing has changed since Load was last called
+// then no work wil be done.
 func (a *Album) Load() error {
 	a.mu.Lock()
 	if a.loading {
@@ -71,83 +187,259 @@ func (a *Album) Load() error {
 		return errors.New("album: load already in progress")
 	}
 	a.loading = true
-	defer func() { a.loading = false }()
 	a.mu.Unlock()
 
-	log.Println("album: loading image metadata")
+	start := time.Now()
+	events, err := a.load()
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	a.metrics.observe("dbps_load_duration_seconds", labels("outcome", outcome), time.Since(start))
 
-	f, err := a.dropbox.Files.ListFolder(&dropbox.ListFolderInput{
-		Path:             a.folder,
-		Limit:            2000,
-		IncludeMediaInfo: true,
-	})
+	// Events are published before another Load can start, so that they arrive
+	// in order.
 	if err != nil {
-		return fmt.Errorf("album: failed to list files: %s", err)
+		events = []Event{{Type: LoadFailed, Time: time.Now(), Err: err}}
 	}
+	a.publish(events)
 
-	files := f.Entries
+	a.mu.Lock()
+	a.loading = false
+	a.loadErr = err
+	if err == nil {
+		a.lastLoad = time.Now()
+		a.failures = 0
+	} else {
+		a.failures++
+	}
+	if err == nil && !a.isReady() {
+		close(a.ready)
+	}
+	a.mu.Unlock()
+	return err
+}
+
+// Ready returns a channel which is closed once the first Load has succeeded.
+func (a *Album) Ready() <-chan struct{} {
+	return a.ready
+}
+
+// Err returns the error from the most recent Load, or nil if it succeeded.
+func (a *Album) Err() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.loadErr
+}
+
+// AlbumStatus describes how well the album is keeping up with its source.
+type AlbumStatus struct {
+	Ready    bool          // Whether a Load has succeeded.
+	Restored bool          // Whether photos were restored from a snapshot.
+	LastLoad time.Time     // When Load last succeeded.
+	Failures int           // Number of consecutive failed loads.
+	Backoff  time.Duration // How long Monitor or Watch will wait before retrying.
+	Photos   int
+	Err      error // Error from the most recent Load.
+}
+
+// Status returns the album's current status.
+func (a *Album) Status() AlbumStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return AlbumStatus{
+		Ready:    a.isReady(),
+		Restored: a.restored,
+		LastLoad: a.lastLoad,
+		Failures: a.failures,
+		Backoff:  a.backoff,
+		Photos:   len(a.photoList),
+		Err:      a.loadErr,
+	}
+}
+
+// Version returns a number which increases whenever the album's photos change,
+// and the time of the last change.
+func (a *Album) Version() (uint64, time.Time) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.version, a.modified
+}
+
+// bumpVersion records a change to the photos. Callers must hold the lock.
+func (a *Album) bumpVersion() {
+	a.version++
+	a.modified = time.Now()
+}
+
+// resetCursor makes the next Load list every file, rather than the changes
+// since the last one, e.g. because the source now belongs to another account.
+func (a *Album) resetCursor() {
+	a.mu.Lock()
+	a.cursor = ""
+	a.resets++
+	a.mu.Unlock()
+}
+
+// setBackoff records how long Monitor or Watch are waiting.
+func (a *Album) setBackoff(d time.Duration) {
+	a.mu.Lock()
+	a.backoff = d
+	a.mu.Unlock()
+}
+
+// isReady returns true if a Load has succeeded.
+func (a *Album) isReady() bool {
+	select {
+	case <-a.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// load does the work for Load, returning the changes that were made.
+func (a *Album) load() ([]Event, error) {
+	a.Logger.Info("album: loading image metadata", "album", a.name)
+	start := time.Now()
+
+	a.mu.RLock()
+	prev, resets := a.cursor, a.resets
+	a.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.ListTimeout)
+	files, cursor, reset, err := a.source.List(ctx, prev)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("album: failed to list files: %w", err)
+	}
+
+	// On a full listing anything that isn't returned has gone away, otherwise
+	// start from the current photos and apply the delta.
+	photoMap := make(map[string]Photo)
+	if !reset {
+		for name, p := range a.photoMap {
+			photoMap[name] = p
+		}
+	}
 
 	var wg sync.WaitGroup
-	photos := make(photoList, len(files))
+	var updated photoList
+	var media []*MediaInfo
+	var pending []int
 
-	c := 0
+	// A delta can list a file more than once, e.g. when it's added and then
+	// deleted, in which case only the last entry applies.
+	last := make(map[string]int, len(files))
 	for i, e := range files {
-		name := path.Base(e.PathLower)
+		last[e.Name] = i
+	}
+
+	for i, e := range files {
+		name := e.Name
+		if last[name] != i {
+			continue
+		}
+		if e.Deleted {
+			delete(photoMap, name)
+			continue
+		}
 
-		// If no entry exists, or the entry is stale, then load the photo to get its
-		// exif data. Loads are done in parallel.
-		if old, ok := a.photoMap[name]; !ok || old.Hash != e.ContentHash {
-			photos[i] = Photo{
+		// If no entry exists, or the entry is stale, then use the source's metadata
+		// or, if it has none, load the photo to get its exif data. Loads are done
+		// in parallel.
+		if old, ok := a.photoMap[name]; !ok || old.Hash != e.Hash {
+			p := Photo{
 				Filename:        name,
-				Size:            int(e.Size),
-				Hash:            e.ContentHash,
-				DropboxModified: e.ServerModified,
+				Size:            e.Size,
+				Hash:            e.Hash,
+				DropboxModified: e.Modified,
 				ExifCreated:     e.ClientModified, // Default to the last modified time.
 			}
-
-			c++
-			wg.Add(1)
-			a.cache.Invalidate(originalCacheKey{name}, true)
-			go a.loadExifInfo(&photos[i], &wg)
-
+			if e.Media == nil || a.AlwaysReadExif {
+				pending = append(pending, len(updated))
+			} else {
+				p.setMedia(e.Media)
+			}
+			updated = append(updated, p)
+			media = append(media, e.Media)
 		} else {
-			photos[i] = old
+			photoMap[name] = old
 		}
 	}
-	if c > 0 {
-		log.Printf("album: waiting for new images to load")
+
+	if len(pending) > 0 {
+		a.Logger.Info("album: waiting for new images to load", "album", a.name, "count", len(pending))
+	} else if len(updated) > 0 {
+		a.Logger.Info("album: new images", "album", a.name, "count", len(updated))
 	} else {
-		log.Printf("album: no new images")
+		a.Logger.Info("album: no new images", "album", a.name)
+	}
+	for _, i := range pending {
+		wg.Add(1)
+		go a.loadExifInfo(&updated[i], media[i], &wg)
 	}
 	wg.Wait()
+
+	for _, p := range updated {
+		photoMap[p.Filename] = p
+	}
+
+	// Photos which have been changed, deleted or renamed are evicted from the
+	// cache, along with any thumbnails that were generated from them.
+	changed := len(updated) > 0 || cursor != prev
+	for name, old := range a.photoMap {
+		if p, ok := photoMap[name]; !ok || p.Hash != old.Hash {
+			if !ok {
+				a.Logger.Debug("album: removing", "filename", name)
+			}
+			a.cache.Invalidate(originalCacheKey{name, old.Hash}, true)
+			changed = true
+		}
+	}
+
+	photos := make(photoList, 0, len(photoMap))
+	for _, p := range photoMap {
+		photos = append(photos, p)
+	}
 	sort.Sort(photos)
 
-	// TODO(dan): Currently we are not clearing the cache of deleted images, for
-	// the existing usecase that is a rare scenario. Can easily be added by
-	// asking for deleted items and checking entry.IsDeleted
+	events := diffPhotos(a.photoMap, photoMap, time.Now())
 
 	a.mu.Lock()
 	a.photoList = photos
-	a.photoMap = make(map[string]Photo)
-	for _, p := range photos {
-		a.photoMap[p.Filename] = p
+	a.photoMap = photoMap
+	if a.resets == resets {
+		// Otherwise the cursor was reset during the load, and is stale.
+		a.cursor = cursor
+	}
+	if len(events) > 0 {
+		a.bumpVersion()
 	}
 	a.mu.Unlock()
 
-	log.Println("album: metadata load complete")
+	if changed {
+		if err := a.saveSnapshot(); err != nil {
+			a.Logger.Error("album: failed to save snapshot", "album", a.name, "err", err)
+		}
+	}
 
-	return nil
+	a.Logger.Info("album: metadata load complete", "album", a.name, "photos", len(photos), "duration", time.Since(start))
+
+	return events, nil
 }
 
 // FirstPhoto returns the ... first photo.
 func (a *Album) FirstPhoto() Photo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.photoList[0]
 }
 
 // Photo returns the metadata for a photo and the image data, or an error if it doesn't exist.
 func (a *Album) Photo(name string) (Photo, []byte, error) {
-	if photo, ok := a.photoMap[name]; ok {
-		data, err := a.cache.Get(originalCacheKey{name})
+	if photo, ok := a.lookup(name); ok {
+		data, err := a.get("original", originalCacheKey{name, photo.Hash})
 		return photo, data, err
 	}
 	return Photo{}, nil, fmt.Errorf("album: no photo with name: %s", name)
@@ -155,70 +447,273 @@ func (a *Album) Photo(name string) (Photo, []byte, error) {
 
 // Thumbnail returns the metadata for a photo and a thumbnail, or an error if it doesn't exist.
 func (a *Album) Thumbnail(name string, width, height uint) (Photo, []byte, error) {
-	if photo, ok := a.photoMap[name]; ok {
-		data, err := a.cache.Get(thumbCacheKey{name, width, height})
+	if photo, ok := a.lookup(name); ok {
+		data, err := a.get("thumbnail", thumbCacheKey{name, photo.Hash, width, height})
 		return photo, data, err
 	}
 	return Photo{}, nil, fmt.Errorf("album: no photo with name: %s", name)
 }
 
+// lookup returns the metadata for a photo.
+func (a *Album) lookup(name string) (Photo, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	photo, ok := a.photoMap[name]
+	return photo, ok
+}
+
 // Photos returns a copy of the PhotoList.
 func (a *Album) Photos() []Photo {
+	photos, _, _ := a.versionedPhotos()
+	return photos
+}
+
+// versionedPhotos returns a copy of the photos along with their version and
+// when they last changed.
+func (a *Album) versionedPhotos() ([]Photo, uint64, time.Time) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 	c := make(photoList, len(a.photoList))
 	copy(c, a.photoList)
-	return c
+	return c, a.version, a.modified
 }
 
-func (a *Album) loadExifInfo(p *Photo, wg *sync.WaitGroup) {
+// loadExifInfo reads the photo's metadata from its exif data. Metadata from the
+// source, if any, takes precedence. In that case the exif data is only read when
+// AlwaysReadExif is set, so failures are logged as debug messages.
+func (a *Album) loadExifInfo(p *Photo, m *MediaInfo, wg *sync.WaitGroup) {
 	defer func() { wg.Done() }()
+	defer p.setMedia(m)
+
+	logError := a.Logger.Error
+	if m != nil {
+		logError = a.Logger.Debug
+	}
 
-	data, err := a.cache.Get(originalCacheKey{p.Filename})
+	ctx, cancel := context.WithTimeout(context.Background(), a.DownloadTimeout)
+	defer cancel()
+
+	data, err := a.readExifData(ctx, p)
 	if err != nil {
-		log.Printf("album: error renewing cache for %s: %s", p, err)
+		logError("album: error fetching exif data", "filename", p.Filename, "err", err)
 		return
 	}
 
 	x, err := exif.Decode(bytes.NewReader(data))
 	if err != nil {
-		log.Printf("album: error reading exif for %s: %s", p, err)
+		logError("album: error reading exif", "filename", p.Filename, "err", err)
 		return
 	}
 
+	p.setExif(x)
+
 	t, err := x.DateTime()
 	if err != nil {
-		log.Printf("album: error reading exif datetime for %s: %s", p, err)
+		logError("album: error reading exif datetime", "filename", p.Filename, "err", err)
 		return
 	}
 
 	p.ExifCreated = t
 }
 
+// How long to wait for a source to report changes before checking again.
+const watchTimeout = time.Minute * 10
+
+// Size of the first request when reading EXIF data from the start of a file,
+// and the most that will be read before giving up.
+const (
+	exifChunkSize = 64 * 1024
+	exifMaxSize   = 1024 * 1024
+)
+
+// readExifData returns enough of the start of a photo to decode its EXIF data.
+// If the source doesn't support ranged reads, the file is neither a JPEG nor has
+// EXIF in its first chunk, or the original is already on disk, then the whole
+// file is returned.
+func (a *Album) readExifData(ctx context.Context, p *Photo) ([]byte, error) {
+	ro, ok := a.source.(RangeOpener)
+	if !ok {
+		return a.get("original", originalCacheKey{p.Filename, p.Hash})
+	}
+	if data, ok := a.fromDisk(p.Hash); ok {
+		return data, nil
+	}
+
+	var data []byte
+	want := exifChunkSize
+	for {
+		more, err := readRange(ctx, ro, p.Filename, int64(len(data)), int64(want-len(data)))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, more...)
+
+		// A short read means the whole file has been read.
+		if len(data) < want {
+			return data, nil
+		}
+
+		size, ok := exifSize(data)
+		if !ok {
+			// TIFF based formats, including most RAW files, have their IFDs near
+			// the start, so only download the whole file if they can't be read.
+			if _, err := exif.Decode(bytes.NewReader(data)); err == nil {
+				return data, nil
+			}
+			return a.get("original", originalCacheKey{p.Filename, p.Hash})
+		} else if size <= len(data) {
+			return data[:size], nil
+		} else if size > exifMaxSize {
+			return nil, fmt.Errorf("album: no exif data in first %d bytes", exifMaxSize)
+		}
+		want = size
+	}
+}
+
+// readRange reads part of a file from a source.
+func readRange(ctx context.Context, ro RangeOpener, name string, offset, length int64) ([]byte, error) {
+	r, err := ro.OpenRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(io.LimitReader(r, length))
+}
+
+// exifSize walks the segments at the start of a JPEG and returns how many
+// bytes are needed to include the EXIF (APP1) segment. If more data is needed
+// to find it the returned size will be larger than len(data), if the image has
+// no EXIF the returned size is where the headers end. Returns false if the data
+// isn't a JPEG.
+func exifSize(data []byte) (int, bool) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, false
+	}
+	pos := 2
+	for {
+		if pos+4 > len(data) {
+			return pos + 4, true
+		}
+		if data[pos] != 0xFF {
+			return 0, false
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Fill byte.
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan or end of image, so there aren't any more headers.
+			return pos, true
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if marker == 0xE1 {
+			return end, true
+		}
+		pos = end
+	}
+}
+
+// get returns an original or thumbnail from the cache, fetching it on a miss.
+func (a *Album) get(kind string, key interface{}) ([]byte, error) {
+	a.metrics.add("dbps_cache_requests_total", labels("cache", kind), 1)
+	return a.cache.Get(key)
+}
+
 func (a *Album) fetchOriginal(key originalCacheKey) ([]byte, error) {
-	// TODO(dan): Add timeout, Download gets stuck.
+	if data, ok := a.fromDisk(key.Hash); ok {
+		a.metrics.add("dbps_cache_hits_total", labels("cache", "original", "tier", "disk"), 1)
+		return data, nil
+	}
+	a.metrics.add("dbps_cache_misses_total", labels("cache", "original"), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.DownloadTimeout)
+	defer cancel()
+
 	filename := key.Filename
-	log.Printf("album: fetching %s", filename)
-	resp, err := a.dropbox.Files.Download(&dropbox.DownloadInput{
-		Path: path.Join(a.folder, filename),
-	})
+	start := time.Now()
+	r, err := a.source.Open(ctx, filename)
 	if err != nil {
 		return []byte{}, err
 	}
-	return ioutil.ReadAll(resp.Body)
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return []byte{}, err
+	}
+	a.metrics.add("dbps_cache_fetched_bytes_total", labels("cache", "original"), float64(len(data)))
+	a.Logger.Debug("album: fetched", "filename", filename, "key", key, "size", len(data), "duration", time.Since(start))
+
+	a.toDisk(key.Hash, data)
+	return data, nil
 }
 
 func (a *Album) fetchThumbnail(key thumbCacheKey) ([]byte, error) {
-	data, err := a.cache.Get(originalCacheKey{key.Filename})
+	// Thumbnails can only be stored on disk if the source provides a hash.
+	diskKey := ""
+	if key.Hash != "" {
+		diskKey = fmt.Sprintf("%s-%dx%d", key.Hash, key.Width, key.Height)
+	}
+	if data, ok := a.fromDisk(diskKey); ok {
+		a.metrics.add("dbps_cache_hits_total", labels("cache", "thumbnail", "tier", "disk"), 1)
+		return data, nil
+	}
+	a.metrics.add("dbps_cache_misses_total", labels("cache", "thumbnail"), 1)
+
+	data, err := a.get("original", originalCacheKey{key.Filename, key.Hash})
 	if err != nil {
 		return []byte{}, err
 	}
-	log.Printf("album: resizing %s", key.Filename)
-	return Resize(data, key.Width, key.Height)
+	ctx, cancel := context.WithTimeout(context.Background(), a.ResizeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	data, err = ResizeContext(ctx, data, key.Width, key.Height)
+	if err != nil {
+		return []byte{}, err
+	}
+	a.metrics.observe("dbps_resize_duration_seconds", labels("size", sizeBucket(key.Width, key.Height)), time.Since(start))
+	a.metrics.add("dbps_cache_fetched_bytes_total", labels("cache", "thumbnail"), float64(len(data)))
+	a.Logger.Debug("album: resized", "filename", key.Filename, "key", key, "size", len(data), "duration", time.Since(start))
+
+	a.toDisk(diskKey, data)
+	return data, nil
+}
+
+// UseDiskCache stores originals and thumbnails in dir, in addition to memory,
+// so that they survive restarts. Files are keyed by content hash and the least
+// recently used are removed once the directory exceeds maxSize bytes.
+func (a *Album) UseDiskCache(dir string, maxSize int64) error {
+	d, err := newDiskCache(dir, maxSize)
+	if err != nil {
+		return err
+	}
+	a.disk = d
+	return nil
+}
+
+// fromDisk returns data from the disk cache, if one is configured.
+func (a *Album) fromDisk(key string) ([]byte, bool) {
+	if a.disk == nil || key == "" {
+		return nil, false
+	}
+	return a.disk.Get(key)
+}
+
+// toDisk writes data to the disk cache, if one is configured.
+func (a *Album) toDisk(key string, data []byte) {
+	if a.disk == nil || key == "" {
+		return
+	}
+	if err := a.disk.Put(key, data); err != nil {
+		a.Logger.Error("album: failed to write to disk cache", "key", key, "err", err)
+	}
 }
 
 type originalCacheKey struct {
 	Filename string
+	Hash     string
 }
 
 func (o originalCacheKey) String() string {
@@ -227,12 +722,13 @@ func (o originalCacheKey) String() string {
 
 type thumbCacheKey struct {
 	Filename string
+	Hash     string
 	Width    uint
 	Height   uint
 }
 
 func (t thumbCacheKey) Dependencies() []interface{} {
-	return []interface{}{originalCacheKey{t.Filename}}
+	return []interface{}{originalCacheKey{t.Filename, t.Hash}}
 }
 
 func (t thumbCacheKey) String() string {
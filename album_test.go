@@ -0,0 +1,467 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpup/dbps/internal/dropbox"
+	"github.com/dpup/dbps/internal/dropbox/dropboxtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// requestCounter counts the API calls made to the fake server, by path.
+type requestCounter struct {
+	next  http.RoundTripper
+	calls map[string]int
+	mu    sync.Mutex
+}
+
+func (c *requestCounter) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.calls[strings.TrimPrefix(r.URL.Path, "/2/")]++
+	c.mu.Unlock()
+	return c.next.RoundTrip(r)
+}
+
+// count returns how many calls have been made to an endpoint, and resets it.
+func (c *requestCounter) count(endpoint string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.calls[endpoint]
+	delete(c.calls, endpoint)
+	return n
+}
+
+// newTestAlbum returns an album for the /photos folder of a fake Dropbox,
+// listing limit entries per page.
+func newTestAlbum(t *testing.T, s *dropboxtest.Server, limit uint32) (*Album, *requestCounter) {
+	config := s.Config()
+	rc := &requestCounter{next: config.HTTPClient.Transport, calls: make(map[string]int)}
+	config.HTTPClient = &http.Client{Transport: rc}
+
+	ds := newDropboxSource(dropbox.New(config), "/photos")
+	ds.limit = limit
+	a := NewAlbum(t.Name(), ds)
+	a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return a, rc
+}
+
+// filenames returns the names of the album's photos, sorted.
+func filenames(a *Album) []string {
+	var names []string
+	for _, p := range a.Photos() {
+		names = append(names, p.Filename)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAlbum_Load_pages(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s.Put("/photos/"+name+".jpg", []byte(name))
+	}
+
+	a, rc := newTestAlbum(t, s, 2)
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"}, filenames(a))
+	assert.Equal(t, 1, rc.count("files/list_folder"))
+	assert.Equal(t, 2, rc.count("files/list_folder/continue"))
+
+	// Nothing has changed, so only the cursor is checked.
+	v, _ := a.Version()
+	assert.NoError(t, a.Load())
+	assert.Equal(t, 0, rc.count("files/list_folder"))
+	assert.Equal(t, 1, rc.count("files/list_folder/continue"))
+	v2, _ := a.Version()
+	assert.Equal(t, v, v2)
+}
+
+func TestAlbum_Load_delta(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	s.Put("/photos/a.jpg", []byte("a"))
+	s.Put("/photos/b.jpg", []byte("b"))
+	s.Put("/photos/c.jpg", []byte("c"))
+
+	a, rc := newTestAlbum(t, s, 2)
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"a.jpg", "b.jpg", "c.jpg"}, filenames(a))
+	rc.count("files/list_folder")
+	rc.count("files/list_folder/continue")
+
+	s.Put("/photos/d.jpg", []byte("d"))
+	s.Put("/photos/b.jpg", []byte("bigger"))
+	s.Remove("/photos/c.jpg")
+
+	// The three changes are returned in two pages.
+	assert.NoError(t, a.Load())
+	assert.Equal(t, 0, rc.count("files/list_folder"))
+	assert.Equal(t, 2, rc.count("files/list_folder/continue"))
+	assert.Equal(t, []string{"a.jpg", "b.jpg", "d.jpg"}, filenames(a))
+
+	p, data, err := a.Photo("b.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, p.Size)
+	assert.Equal(t, "bigger", string(data))
+}
+
+func TestAlbum_Load_reset(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	s.Put("/photos/a.jpg", []byte("a"))
+	s.Put("/photos/b.jpg", []byte("b"))
+
+	a, rc := newTestAlbum(t, s, 2)
+	assert.NoError(t, a.Load())
+	assert.Equal(t, 1, rc.count("files/list_folder"))
+
+	s.ResetCursors()
+	s.Put("/photos/c.jpg", []byte("c"))
+
+	assert.NoError(t, a.Load())
+	assert.Equal(t, 1, rc.count("files/list_folder"))
+	assert.Equal(t, []string{"a.jpg", "b.jpg", "c.jpg"}, filenames(a))
+
+	// The new cursor is used from then on.
+	assert.NoError(t, a.Load())
+	assert.Equal(t, 0, rc.count("files/list_folder"))
+}
+
+// testJPEG returns an encoded w x h image.
+func testJPEG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil)
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestAlbum_Load_remove(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	s.Put("/photos/a.jpg", testJPEG(t, 20, 10))
+	s.Put("/photos/b.jpg", testJPEG(t, 20, 10))
+
+	a, _ := newTestAlbum(t, s, 10)
+	assert.NoError(t, a.Load())
+	_, _, err := a.Thumbnail("a.jpg", 5, 5)
+	assert.NoError(t, err)
+
+	s.Remove("/photos/a.jpg")
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"b.jpg"}, filenames(a))
+	_, ok := a.photoMap["a.jpg"]
+	assert.False(t, ok)
+
+	_, _, err = a.Photo("a.jpg")
+	assert.Error(t, err)
+	_, _, err = a.Thumbnail("a.jpg", 5, 5)
+	assert.Error(t, err)
+}
+
+func TestAlbum_Load_move(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	data := testJPEG(t, 20, 10)
+	s.Put("/photos/a.jpg", data)
+
+	a, _ := newTestAlbum(t, s, 10)
+	assert.NoError(t, a.Load())
+	_, _, err := a.Thumbnail("a.jpg", 5, 5)
+	assert.NoError(t, err)
+
+	s.Move("/photos/a.jpg", "/photos/renamed.jpg")
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"renamed.jpg"}, filenames(a))
+	_, ok := a.photoMap["a.jpg"]
+	assert.False(t, ok)
+
+	_, _, err = a.Photo("a.jpg")
+	assert.Error(t, err)
+	_, _, err = a.Thumbnail("a.jpg", 5, 5)
+	assert.Error(t, err)
+
+	_, got, err := a.Photo("renamed.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	_, _, err = a.Thumbnail("renamed.jpg", 5, 5)
+	assert.NoError(t, err)
+}
+
+// memSource serves a single file from memory, counting the bytes read.
+type memSource struct {
+	data []byte
+	read int
+}
+
+func (m *memSource) List(ctx context.Context, cursor string) ([]Entry, string, bool, error) {
+	return []Entry{{Name: "a.jpg", Size: len(m.data)}}, "", true, nil
+}
+
+func (m *memSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return m.OpenRange(ctx, name, 0, int64(len(m.data)))
+}
+
+func (m *memSource) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	end := offset + length
+	if end > int64(len(m.data)) {
+		end = int64(len(m.data))
+	}
+	m.read += int(end - offset)
+	return ioutil.NopCloser(bytes.NewReader(m.data[offset:end])), nil
+}
+
+// segment returns a JPEG marker segment with size bytes of content.
+func segment(marker byte, size int) []byte {
+	s := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(s[2:], uint16(size+2))
+	return append(s, make([]byte, size)...)
+}
+
+// jpegData joins segments after a start of image marker.
+func jpegData(segments ...[]byte) []byte {
+	return bytes.Join(append([][]byte{{0xFF, 0xD8}}, segments...), nil)
+}
+
+// tiffData returns a little endian TIFF of the given size, with a Make tag.
+func tiffData(size int) []byte {
+	data := make([]byte, size)
+	copy(data, "II*\x00")
+	binary.LittleEndian.PutUint32(data[4:], 8) // Offset of the first IFD.
+	binary.LittleEndian.PutUint16(data[8:], 1) // Number of entries.
+	binary.LittleEndian.PutUint16(data[10:], 0x010F)
+	binary.LittleEndian.PutUint16(data[12:], 2) // ASCII.
+	binary.LittleEndian.PutUint32(data[14:], 4)
+	copy(data[18:], "abc\x00")
+	return data // The next IFD offset, at 22, is left as zero.
+}
+
+func TestAlbum_readExifData(t *testing.T) {
+	// Image data, so that files are larger than the first chunk.
+	scan := append(segment(0xDA, 10), make([]byte, exifChunkSize*2)...)
+
+	// Enough APP2 segments to push the EXIF past the maximum size.
+	var app2 [][]byte
+	for i := 0; i*0xFFF0 < exifMaxSize; i++ {
+		app2 = append(app2, segment(0xE2, 0xFFF0))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int // Bytes returned, or -1 for an error.
+		read int // Bytes read from the source, or the most that can be read.
+	}{
+		{
+			name: "app1 after app0",
+			data: jpegData(segment(0xE0, 14), segment(0xE1, 1000), scan),
+			want: 2 + 18 + 1004,
+			read: exifChunkSize,
+		},
+		{
+			name: "app1 past first chunk",
+			data: jpegData(segment(0xE0, 14), segment(0xE1, 0xFFF0), scan),
+			want: 2 + 18 + 0xFFF4,
+			read: 2 + 18 + 0xFFF4,
+		},
+		{
+			name: "no exif",
+			data: jpegData(segment(0xE0, 14), segment(0xDB, 100), scan),
+			want: 2 + 18 + 104,
+			read: exifChunkSize,
+		},
+		{
+			name: "not a jpeg",
+			data: append([]byte("GIF89a"), scan...),
+			want: 6 + len(scan),
+			read: exifChunkSize + 6 + len(scan),
+		},
+		{
+			name: "tiff",
+			data: tiffData(exifChunkSize * 2),
+			want: exifChunkSize,
+			read: exifChunkSize,
+		},
+		{
+			name: "small file",
+			data: jpegData(segment(0xE1, 100)),
+			want: 2 + 104,
+			read: 2 + 104,
+		},
+		{
+			name: "too large",
+			data: jpegData(append(app2, segment(0xE1, 100), scan)...),
+			want: -1,
+			read: exifMaxSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &memSource{data: tt.data}
+			a := NewAlbum(t.Name(), src)
+			a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+			p := &Photo{Filename: "a.jpg"}
+
+			data, err := a.readExifData(context.Background(), p)
+			if tt.want < 0 {
+				assert.Error(t, err)
+				assert.True(t, src.read <= tt.read, src.read)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, len(data))
+			assert.True(t, bytes.Equal(tt.data[:len(data)], data))
+			assert.Equal(t, tt.read, src.read)
+		})
+	}
+}
+
+func TestAlbum_Load_mediaInfo(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	taken := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a", "b", "c"} {
+		s.Put("/photos/"+name+".jpg", testJPEG(t, 20, 10))
+		s.SetMediaInfo("/photos/"+name+".jpg", &dropbox.MediaInfo{
+			Metadata: &dropbox.MediaMetadata{Photo: &dropbox.PhotoMetadata{
+				Dimensions: &dropbox.Dimensions{Width: 20, Height: 10},
+				TimeTaken:  taken,
+			}},
+		})
+	}
+
+	// Photos with media_info are loaded without downloading them.
+	t.Run("default", func(t *testing.T) {
+		a, rc := newTestAlbum(t, s, 10)
+		assert.NoError(t, a.Load())
+		assert.Equal(t, 0, rc.count("files/download"))
+		for _, p := range a.Photos() {
+			assert.Equal(t, taken, p.ExifCreated)
+			assert.Equal(t, 20, p.Width)
+			assert.Equal(t, 10, p.Height)
+		}
+	})
+
+	t.Run("always read exif", func(t *testing.T) {
+		a, rc := newTestAlbum(t, s, 10)
+		a.AlwaysReadExif = true
+		assert.NoError(t, a.Load())
+		assert.Equal(t, 3, rc.count("files/download"))
+		for _, p := range a.Photos() {
+			assert.Equal(t, taken, p.ExifCreated)
+		}
+	})
+}
+
+// deltaSource lists a fixed set of entries, as if they were a delta.
+type deltaSource struct {
+	memSource
+	entries []Entry
+}
+
+func (d *deltaSource) List(ctx context.Context, cursor string) ([]Entry, string, bool, error) {
+	return d.entries, "cursor", cursor == "", nil
+}
+
+func TestAlbum_Load_repeatedEntries(t *testing.T) {
+	src := &deltaSource{entries: []Entry{{Name: "a.jpg", Hash: "1"}}}
+	a := NewAlbum(t.Name(), src)
+	a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"a.jpg"}, filenames(a))
+
+	// Entries apply in order, so a file that's added then deleted is gone.
+	src.entries = []Entry{
+		{Name: "b.jpg", Hash: "1"},
+		{Name: "b.jpg", Deleted: true},
+		{Name: "a.jpg", Deleted: true},
+		{Name: "a.jpg", Hash: "2"},
+	}
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"a.jpg"}, filenames(a))
+	p, _, _ := a.Photo("a.jpg")
+	assert.Equal(t, "2", p.Hash)
+}
+
+func TestAlbum_Photo_concurrentLoad(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	s.Put("/photos/a.jpg", []byte("a"))
+
+	a, _ := newTestAlbum(t, s, 10)
+	assert.NoError(t, a.Load())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			s.Put("/photos/b.jpg", []byte{byte(i)})
+			a.Load()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			a.Photo("a.jpg")
+			a.Thumbnail("a.jpg", 5, 5)
+			a.FirstPhoto()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestAlbum_Load_retryError(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	s.Put("/photos/a.jpg", []byte("a"))
+
+	config := s.Config()
+	config.MaxRetries = 2
+	config.RetryBackoff = time.Millisecond
+	a := NewAlbum(t.Name(), newDropboxSource(dropbox.New(config), "/photos"))
+	a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s.FailNext(3, 429)
+	assert.Error(t, a.Load())
+
+	// The retries are available from the album's error.
+	var e *dropbox.Error
+	assert.True(t, errors.As(a.Err(), &e))
+	assert.Equal(t, 2, e.Retries)
+	assert.Equal(t, "too_many_requests", e.RetryReason)
+	assert.Contains(t, a.Err().Error(), "after 2 retries, last for too_many_requests")
+}
+
+func TestAlbum_resetCursor(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	s.Put("/photos/a.jpg", []byte("a"))
+
+	a, rc := newTestAlbum(t, s, 10)
+	assert.NoError(t, a.Load())
+	assert.Equal(t, 1, rc.count("files/list_folder"))
+
+	a.resetCursor()
+	assert.NoError(t, a.Load())
+	assert.Equal(t, 1, rc.count("files/list_folder"))
+	assert.Equal(t, 0, rc.count("files/list_folder/continue"))
+	assert.Equal(t, []string{"a.jpg"}, filenames(a))
+}
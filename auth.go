@@ -0,0 +1,187 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpup/dbps/internal/dropbox"
+)
+
+// TokenStore persists the refresh token obtained when an account is connected
+// using the AuthHandler, so that the site can use it after a restart.
+type TokenStore interface {
+	// Token returns the stored refresh token, or an empty string if there isn't
+	// one yet.
+	Token() (string, error)
+
+	// SetToken replaces the stored refresh token.
+	SetToken(token string) error
+}
+
+// NewFileTokenStore returns a TokenStore which keeps the token in a file that's
+// only readable by its owner.
+func NewFileTokenStore(filename string) TokenStore {
+	return fileTokenStore(filename)
+}
+
+type fileTokenStore string
+
+func (f fileTokenStore) Token() (string, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+func (f fileTokenStore) SetToken(token string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(string(f)), ".token-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(token)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), string(f))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// authTimeout is how long an admin has to approve access on Dropbox.
+const authTimeout = 10 * time.Minute
+
+// Sends an admin through Dropbox's authorization code flow, using PKCE, and
+// handles the redirect back. The same URL serves both, so it should be the
+// redirect URI registered for the app. It must only be reachable by admins.
+type authHandler struct {
+	config   *dropbox.Config
+	redirect string
+	store    TokenStore
+	connect  func(token string)
+	logger   Logger
+
+	pending map[string]pendingAuth // By state.
+	mu      sync.Mutex
+}
+
+// pendingAuth is an authorization that the admin hasn't completed yet.
+type pendingAuth struct {
+	verifier string
+	redirect string
+	expires  time.Time
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.config.AppKey == "" {
+		http.Error(w, "Dropbox app key not configured", 404)
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case q.Get("error") != "":
+		http.Error(w, "Dropbox authorization failed: "+q.Get("error_description"), 403)
+	case q.Get("code") != "":
+		h.callback(w, r)
+	default:
+		h.start(w, r)
+	}
+}
+
+// start redirects the admin to Dropbox to approve access.
+func (h *authHandler) start(w http.ResponseWriter, r *http.Request) {
+	p := pendingAuth{
+		verifier: dropbox.NewVerifier(),
+		redirect: h.redirectURL(r),
+		expires:  time.Now().Add(authTimeout),
+	}
+	state := dropbox.NewVerifier()
+
+	h.mu.Lock()
+	for s, old := range h.pending {
+		if time.Now().After(old.expires) {
+			delete(h.pending, s)
+		}
+	}
+	h.pending[state] = p
+	h.mu.Unlock()
+
+	http.Redirect(w, r, h.config.AuthCodeURL(&dropbox.AuthCodeInput{
+		RedirectURL: p.redirect,
+		State:       state,
+		Verifier:    p.verifier,
+	}), http.StatusFound)
+}
+
+// callback exchanges the code for a refresh token, which is stored and used by
+// the site from then on.
+func (h *authHandler) callback(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+
+	h.mu.Lock()
+	p, ok := h.pending[state]
+	delete(h.pending, state)
+	h.mu.Unlock()
+
+	if !ok || time.Now().After(p.expires) {
+		http.Error(w, "Authorization expired, please try again", 400)
+		return
+	}
+
+	out, err := h.config.ExchangeCode(r.Context(), &dropbox.ExchangeCodeInput{
+		Code:        r.URL.Query().Get("code"),
+		RedirectURL: p.redirect,
+		Verifier:    p.verifier,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), 502)
+		return
+	}
+	if out.RefreshToken == "" {
+		http.Error(w, "Dropbox didn't return a refresh token", 502)
+		return
+	}
+
+	if h.store != nil {
+		if err := h.store.SetToken(out.RefreshToken); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	h.logger.Info("dbps: connected Dropbox account", "account", out.AccountID)
+	h.connect(out.RefreshToken)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Dropbox account connected.")
+}
+
+// redirectURL returns the configured redirect URI, or the URL of the request
+// without its query if there isn't one. The path is taken from the RequestURI,
+// as the URL's may have had a prefix stripped.
+func (h *authHandler) redirectURL(r *http.Request) string {
+	if h.redirect != "" {
+		return h.redirect
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	p := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		p = u.Path
+	}
+	return scheme + "://" + r.Host + p
+}
@@ -0,0 +1,186 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dpup/dbps/internal/dropbox/dropboxtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAuthHandler returns a handler which connects accounts on the fake
+// Dropbox, storing tokens in a file in a temp dir. The returned slice holds the
+// tokens passed to connect.
+func newTestAuthHandler(t *testing.T, s *dropboxtest.Server) (*authHandler, *[]string) {
+	config := s.Config()
+	config.AppKey = "key"
+	var connected []string
+	h := &authHandler{
+		config:  config,
+		store:   NewFileTokenStore(filepath.Join(t.TempDir(), "token")),
+		pending: make(map[string]pendingAuth),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		connect: func(token string) { connected = append(connected, token) },
+	}
+	return h, &connected
+}
+
+// authorize starts an authorization and returns the callback URL that Dropbox
+// redirects the admin to.
+func authorize(t *testing.T, h *authHandler) string {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/connect", nil))
+	if !assert.Equal(t, 302, w.Code) {
+		return ""
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(w.Header().Get("Location"))
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	res.Body.Close()
+	return res.Header.Get("Location")
+}
+
+// callback serves the callback URL and returns the status code.
+func callback(h *authHandler, callbackURL string) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", callbackURL, nil))
+	return w.Code
+}
+
+func TestAuthHandler_redirectURL(t *testing.T) {
+	h := &authHandler{}
+
+	// Mounted under a prefix, the redirect URI keeps the full path.
+	var got string
+	handler := http.StripPrefix("/admin", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = h.redirectURL(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/admin/dropbox?x=1", nil))
+	assert.Equal(t, "http://example.com/admin/dropbox", got)
+
+	h.redirect = "https://example.com/connect"
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/admin/dropbox", nil))
+	assert.Equal(t, "https://example.com/connect", got)
+}
+
+func TestAuthHandler(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	h, connected := newTestAuthHandler(t, s)
+
+	u := authorize(t, h)
+	assert.Contains(t, u, "http://example.com/connect?")
+	assert.Equal(t, 200, callback(h, u))
+	assert.Equal(t, []string{s.RefreshToken}, *connected)
+	token, err := h.store.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, s.RefreshToken, token)
+
+	// Each state can only be used once.
+	assert.Equal(t, 400, callback(h, u))
+	assert.Equal(t, 0, len(h.pending))
+	assert.Equal(t, 1, len(*connected))
+}
+
+func TestAuthHandler_state(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	h, connected := newTestAuthHandler(t, s)
+
+	u, err := url.Parse(authorize(t, h))
+	if !assert.NoError(t, err) {
+		return
+	}
+	q := u.Query()
+	state := q.Get("state")
+
+	// The code can't be used with a state from another session.
+	q.Set("state", "other")
+	assert.Equal(t, 400, callback(h, "http://example.com/connect?"+q.Encode()))
+	q.Del("state")
+	assert.Equal(t, 400, callback(h, "http://example.com/connect?"+q.Encode()))
+
+	// Nor once the authorization has expired.
+	h.mu.Lock()
+	p := h.pending[state]
+	p.expires = time.Now().Add(-time.Second)
+	h.pending[state] = p
+	h.mu.Unlock()
+	assert.Equal(t, 400, callback(h, u.String()))
+	assert.Equal(t, 0, len(*connected))
+
+	// Expired authorizations are dropped when the next one starts.
+	authorize(t, h)
+	h.pending[state] = p
+	authorize(t, h)
+	assert.Equal(t, 2, len(h.pending))
+	for _, p := range h.pending {
+		assert.True(t, time.Until(p.expires) > authTimeout-time.Minute)
+	}
+}
+
+func TestAuthHandler_errors(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	h, connected := newTestAuthHandler(t, s)
+
+	// The admin declined access.
+	assert.Equal(t, 403, callback(h, "http://example.com/connect?error=access_denied"))
+
+	// The code is rejected by Dropbox.
+	u, _ := url.Parse(authorize(t, h))
+	q := u.Query()
+	q.Set("code", "wrong")
+	assert.Equal(t, 502, callback(h, "http://example.com/connect?"+q.Encode()))
+
+	// The token can't be stored.
+	h.store = NewFileTokenStore(filepath.Join(t.TempDir(), "missing", "token"))
+	assert.Equal(t, 500, callback(h, authorize(t, h)))
+	assert.Equal(t, 0, len(*connected))
+
+	// The handler is disabled without an app key.
+	h.config.AppKey = ""
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/connect", nil))
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "token")
+	store := NewFileTokenStore(filename)
+
+	token, err := store.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "", token)
+
+	assert.NoError(t, store.SetToken("first"))
+	assert.NoError(t, store.SetToken("second"))
+	token, err = store.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+
+	fi, err := os.Stat(filename)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	// No temp files are left behind.
+	fis, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(fis))
+}
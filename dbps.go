@@ -7,8 +7,8 @@
 package dbps
 
 import (
-	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/dpup/dbps/internal/dropbox"
@@ -19,6 +19,61 @@ type Config struct {
 	DropBoxAccessToken string
 	PhotoFolder        string
 	PollFreq           time.Duration
+
+	// DropBoxClientID and DropBoxClientSecret are the app key and secret from
+	// the App Console. Along with DropBoxRefreshToken they let the site fetch new
+	// access tokens as they expire, in which case DropBoxAccessToken isn't needed.
+	DropBoxClientID     string
+	DropBoxClientSecret string
+	DropBoxRefreshToken string
+
+	// DropBoxMaxRetries is how many times a Dropbox call is retried after a
+	// network error, a server error or being rate limited, defaulting to 3. Use a
+	// negative value to disable retries. DropBoxRetryBackoff is the delay before
+	// the first retry, defaulting to a second, and doubles after that.
+	DropBoxMaxRetries   int
+	DropBoxRetryBackoff time.Duration
+
+	// DropBoxRedirectURL is where the AuthHandler is served, and must be
+	// registered as a redirect URI for the app. If empty it's taken from the
+	// request, which may not be right behind a proxy.
+	DropBoxRedirectURL string
+
+	// TokenStore, if set, saves the refresh token when an account is connected
+	// with the AuthHandler. A stored token takes precedence over
+	// DropBoxRefreshToken.
+	TokenStore TokenStore
+
+	// LongPoll waits for the source to report changes, using Dropbox's longpoll
+	// endpoint, instead of polling every PollFreq. Sources which don't implement
+	// Watcher are still polled.
+	LongPoll bool
+
+	// CacheDir, if set, is where originals and thumbnails are stored so that they
+	// survive restarts. CacheSize limits the size of the directory in bytes and
+	// defaults to 1GB.
+	CacheDir  string
+	CacheSize int64
+
+	// AlwaysReadExif reads each photo's exif data, for details of the camera and
+	// exposure, even when Dropbox provides the photo's dimensions, location and
+	// time taken. Otherwise photos with media_info are loaded without any
+	// downloads, and their Orientation, camera and exposure fields are empty.
+	AlwaysReadExif bool
+
+	// SnapshotFile, if set, is where the album's metadata is saved so that photos
+	// can be served immediately after a restart, before the first sync.
+	SnapshotFile string
+
+	// Logger receives log messages, if nil they're written to the standard
+	// logger. Quiet drops the debug messages logged for each photo that's
+	// fetched or resized.
+	Logger Logger
+	Quiet  bool
+
+	// Source overrides where photos are loaded from. If nil the Dropbox folder
+	// is used, otherwise PhotoFolder only serves to name the album.
+	Source Source
 }
 
 // PhotoSite provides functionality for binding to your own server mux.
@@ -26,13 +81,133 @@ type PhotoSite struct {
 	DataHandler      http.Handler
 	PhotoHandler     http.Handler
 	ThumbnailHandler http.Handler
-	Album            *Album
+
+	// AuthHandler lets an admin connect a Dropbox account to the site, and must
+	// only be reachable by admins. Until an account is connected, either here or
+	// with a token in the config, the album stays empty.
+	AuthHandler http.Handler
+
+	// HealthHandler reports the album's status as JSON, for use as a readiness
+	// probe. It responds with a 503 until the album has loaded, unless photos
+	// were restored from SnapshotFile, in which case the site is ready to serve
+	// them straight away. Restored and Ready in the JSON tell the two apart.
+	HealthHandler http.Handler
+
+	// MetricsHandler exposes metrics about the album, the cache, Dropbox calls
+	// and the other handlers in the Prometheus text format.
+	MetricsHandler http.Handler
+
+	// EventsHandler streams photos as they're added, updated or removed, using
+	// Server-Sent Events. Clients should connect before fetching the
+	// DataHandler, so that no changes are missed in between.
+	EventsHandler http.Handler
+
+	// WebhookHandler receives Dropbox webhook notifications, signed with
+	// DropBoxClientSecret, and reloads the album within a few seconds. This lets
+	// PollFreq be much longer. It has no effect when using LongPoll.
+	WebhookHandler http.Handler
+
+	Album *Album
 }
 
-// NewPhotoSite fetches data about a photo album from DropBox and monitors for changes.
+// NewPhotoSite fetches data about a photo album from DropBox, or the configured
+// Source, and monitors for changes. Loading happens in the background and
+// failures are retried, use Ready to wait for the album.
 func NewPhotoSite(config Config) *PhotoSite {
-	d := dropbox.New(dropbox.NewConfig(config.DropBoxAccessToken))
-	album := NewAlbum(config.PhotoFolder, d)
+	var logger Logger = stdLogger{}
+	if config.Logger != nil {
+		logger = config.Logger
+	}
+	if config.Quiet {
+		logger = quietLogger{logger}
+	}
+
+	auth := &authHandler{
+		config:   dropbox.NewRefreshConfig(config.DropBoxClientID, config.DropBoxClientSecret, ""),
+		redirect: config.DropBoxRedirectURL,
+		store:    config.TokenStore,
+		pending:  make(map[string]pendingAuth),
+		logger:   logger,
+	}
+
+	source := config.Source
+	var ds *dropboxSource
+	if source == nil {
+		ds = newDropboxSource(nil, config.PhotoFolder)
+		source = ds
+	}
+	album := NewAlbum(config.PhotoFolder, source)
+	album.Logger = logger
+	album.AlwaysReadExif = config.AlwaysReadExif
+
+	newClient := func(dc *dropbox.Config) *dropbox.Client {
+		if config.DropBoxMaxRetries != 0 {
+			dc.MaxRetries = config.DropBoxMaxRetries
+		}
+		if config.DropBoxRetryBackoff > 0 {
+			dc.RetryBackoff = config.DropBoxRetryBackoff
+		}
+		dc.Observer = album.metrics.observeDropbox
+		return dropbox.New(dc)
+	}
+
+	// The album starts loading once an account is connected, which can happen
+	// more than once via the AuthHandler.
+	connected := make(chan struct{})
+	var once sync.Once
+	markConnected := func() { once.Do(func() { close(connected) }) }
+
+	if ds != nil {
+		useToken := func(token string) {
+			ds.setClient(newClient(dropbox.NewRefreshConfig(config.DropBoxClientID, config.DropBoxClientSecret, token)))
+			markConnected()
+		}
+
+		// Accounts connected with the AuthHandler may not be the one the album
+		// was synced with, so their folder is listed from scratch straight away.
+		auth.connect = func(token string) {
+			useToken(token)
+			album.resetCursor()
+			album.Refresh()
+		}
+
+		token := config.DropBoxRefreshToken
+		if config.TokenStore != nil {
+			if t, err := config.TokenStore.Token(); err != nil {
+				logger.Error("dbps: failed to read token", "err", err)
+			} else if t != "" {
+				token = t
+			}
+		}
+
+		if token != "" {
+			useToken(token)
+		} else if config.DropBoxAccessToken != "" {
+			ds.setClient(newClient(dropbox.NewConfig(config.DropBoxAccessToken)))
+			markConnected()
+		} else {
+			logger.Info("dbps: waiting for a Dropbox account to be connected")
+		}
+	} else {
+		auth.connect = func(string) {}
+		markConnected()
+	}
+
+	if config.CacheDir != "" {
+		cs := int64(1 << 30)
+		if config.CacheSize > 0 {
+			cs = config.CacheSize
+		}
+		if err := album.UseDiskCache(config.CacheDir, cs); err != nil {
+			logger.Error("dbps: disk cache disabled", "dir", config.CacheDir, "err", err)
+		}
+	}
+
+	if config.SnapshotFile != "" {
+		if err := album.UseSnapshot(config.SnapshotFile); err != nil {
+			logger.Error("dbps: failed to restore snapshot", "filename", config.SnapshotFile, "err", err)
+		}
+	}
 
 	pf := time.Second * 30
 	if config.PollFreq > 0 {
@@ -40,20 +215,43 @@ func NewPhotoSite(config Config) *PhotoSite {
 	}
 
 	// TODO(dan): Come up with a better way of loading and polling for changes.
-	// This loads all the images, in order to get EXIF data, which has the side
-	// effect of pre-warming teh cache.
+	// This reads the EXIF data for all the images, the originals are fetched on
+	// demand. If the first load fails it's retried by Monitor or Watch, use
+	// Ready and Err to find out when the site has photos to serve.
 	go func() {
-		err := album.Load()
-		if err != nil {
-			log.Fatal(err)
+		<-connected
+		if err := album.Load(); err != nil {
+			logger.Error("dbps: initial load failed", "err", err)
+		}
+		if _, ok := source.(Watcher); ok && config.LongPoll {
+			album.Watch()
+		} else {
+			album.Monitor(pf)
 		}
-		album.Monitor(pf)
 	}()
 
+	m := album.metrics
 	return &PhotoSite{
-		&jsonHandler{album},
-		&photoHandler{album},
-		&thumbnailHandler{album},
+		instrument(m, "data", &jsonHandler{album: album}),
+		instrument(m, "photo", &photoHandler{album}),
+		instrument(m, "thumbnail", &thumbnailHandler{album}),
+		instrument(m, "auth", auth),
+		instrument(m, "health", &healthHandler{album: album}),
+		&metricsHandler{album},
+		instrument(m, "events", newEventsHandler(album)),
+		instrument(m, "webhook", &webhookHandler{album, config.DropBoxClientSecret}),
 		album,
 	}
 }
+
+// Ready returns a channel which is closed once the album has loaded, until then
+// the handlers serve an empty album.
+func (p *PhotoSite) Ready() <-chan struct{} {
+	return p.Album.Ready()
+}
+
+// Err returns the error from the album's most recent load, if it failed. This
+// can be used to report why the site isn't ready.
+func (p *PhotoSite) Err() error {
+	return p.Album.Err()
+}
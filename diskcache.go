@@ -0,0 +1,152 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskCache stores blobs as files in a directory, evicting the least recently
+// used files once the total size exceeds maxSize. Access times are recorded as
+// the file's modification time so that the order survives restarts.
+type diskCache struct {
+	dir     string
+	maxSize int64
+
+	size    int64
+	lru     *list.List // Of *diskEntry, most recently used at the front.
+	entries map[string]*list.Element
+	mu      sync.Mutex
+}
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+// newDiskCache returns a cache using dir, which is created if it doesn't exist.
+// Files left by a previous process are indexed and can be served immediately.
+func newDiskCache(dir string, maxSize int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &diskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	sort.Slice(fis, func(i, j int) bool { return fis[i].ModTime().Before(fis[j].ModTime()) })
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(fi.Name(), ".") {
+			// Partial write from a process that didn't exit cleanly.
+			os.Remove(filepath.Join(dir, fi.Name()))
+			continue
+		}
+		d.entries[fi.Name()] = d.lru.PushFront(&diskEntry{fi.Name(), fi.Size()})
+		d.size += fi.Size()
+	}
+
+	d.mu.Lock()
+	d.evict()
+	d.mu.Unlock()
+
+	return d, nil
+}
+
+// Get returns the data stored under name, if it exists.
+func (d *diskCache) Get(name string) ([]byte, bool) {
+	d.mu.Lock()
+	e, ok := d.entries[name]
+	if ok {
+		d.lru.MoveToFront(e)
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	p := filepath.Join(d.dir, name)
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		d.remove(name)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return data, true
+}
+
+// Put stores data under name, evicting old entries if the cache is full.
+func (d *diskCache) Put(name string, data []byte) error {
+	f, err := ioutil.TempFile(d.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(d.dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[name]; ok {
+		d.size -= e.Value.(*diskEntry).size
+		d.lru.Remove(e)
+	}
+	d.entries[name] = d.lru.PushFront(&diskEntry{name, int64(len(data))})
+	d.size += int64(len(data))
+	d.evict()
+
+	return nil
+}
+
+// remove drops an entry that could no longer be read.
+func (d *diskCache) remove(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[name]; ok {
+		d.size -= e.Value.(*diskEntry).size
+		d.lru.Remove(e)
+		delete(d.entries, name)
+		os.Remove(filepath.Join(d.dir, name))
+	}
+}
+
+// evict removes the least recently used files until the cache fits within
+// maxSize, the most recent entry is always kept. Callers must hold the lock.
+func (d *diskCache) evict() {
+	for d.maxSize > 0 && d.size > d.maxSize && d.lru.Len() > 1 {
+		e := d.lru.Back()
+		de := e.Value.(*diskEntry)
+		d.lru.Remove(e)
+		delete(d.entries, de.name)
+		d.size -= de.size
+		os.Remove(filepath.Join(d.dir, de.name))
+	}
+}
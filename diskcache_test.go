@@ -0,0 +1,90 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cached returns the names of the files in the cache's directory.
+func cached(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDiskCache_evict(t *testing.T) {
+	dir := t.TempDir()
+	d, err := newDiskCache(dir, 25)
+	assert.NoError(t, err)
+
+	data := make([]byte, 10)
+	assert.NoError(t, d.Put("a", data))
+	assert.NoError(t, d.Put("b", data))
+	assert.NoError(t, d.Put("c", data))
+	assert.Equal(t, []string{"b", "c"}, cached(t, dir))
+	assert.Equal(t, int64(20), d.size)
+
+	// Reading b makes c the least recently used.
+	_, ok := d.Get("b")
+	assert.True(t, ok)
+	assert.NoError(t, d.Put("d", data))
+	assert.Equal(t, []string{"b", "d"}, cached(t, dir))
+
+	_, ok = d.Get("c")
+	assert.False(t, ok)
+
+	// Replacing an entry doesn't count it twice.
+	assert.NoError(t, d.Put("d", make([]byte, 5)))
+	assert.Equal(t, int64(15), d.size)
+	assert.Equal(t, []string{"b", "d"}, cached(t, dir))
+
+	// The newest entry is kept even if it's larger than the cache.
+	assert.NoError(t, d.Put("e", make([]byte, 30)))
+	assert.Equal(t, []string{"e"}, cached(t, dir))
+}
+
+func TestDiskCache_restart(t *testing.T) {
+	dir := t.TempDir()
+	d, err := newDiskCache(dir, 100)
+	assert.NoError(t, err)
+
+	data := make([]byte, 10)
+	old := time.Now().Add(-time.Hour)
+	for i, name := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, d.Put(name, data))
+		mtime := old.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, os.Chtimes(filepath.Join(dir, name), mtime, mtime))
+	}
+	_, ok := d.Get("a") // Now the most recently used.
+	assert.True(t, ok)
+
+	// A partial write left by a process that didn't exit cleanly.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".tmp-123"), data, 0644))
+
+	// Restarting with a smaller cap evicts the least recently used files.
+	d, err = newDiskCache(dir, 25)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "d"}, cached(t, dir))
+	assert.Equal(t, int64(20), d.size)
+
+	got, ok := d.Get("d")
+	assert.True(t, ok)
+	assert.Equal(t, data, got)
+	_, ok = d.Get("b")
+	assert.False(t, ok)
+	_, ok = d.Get(".tmp-123")
+	assert.False(t, ok)
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"sort"
+	"time"
+)
+
+// EventType describes what happened to the album.
+type EventType string
+
+// Types of event sent to subscribers.
+const (
+	PhotoAdded   EventType = "added"
+	PhotoUpdated EventType = "updated" // The photo's contents have changed.
+	PhotoRemoved EventType = "removed"
+	LoadFailed   EventType = "load_failed"
+)
+
+// Event is a change to the album found by Load, or a failed Load.
+type Event struct {
+	Type  EventType
+	Time  time.Time
+	Photo Photo // The new photo, or the old one if it was removed.
+	Err   error // Set for LoadFailed.
+}
+
+// Subscribe calls fn with each event, in order, after the Load that found it
+// has completed. A rename is reported as the removal of the old photo and the
+// addition of the new one. The returned function cancels the subscription.
+//
+// Events are delivered synchronously, so fn should return quickly, or hand the
+// event off to another goroutine.
+func (a *Album) Subscribe(fn func(Event)) (cancel func()) {
+	a.subMu.Lock()
+	defer a.subMu.Unlock()
+	a.nextSub++
+	id := a.nextSub
+	if a.subscribers == nil {
+		a.subscribers = make(map[int]func(Event))
+	}
+	a.subscribers[id] = fn
+	return func() {
+		a.subMu.Lock()
+		delete(a.subscribers, id)
+		a.subMu.Unlock()
+	}
+}
+
+// publish sends events to the subscribers, in the order they subscribed.
+func (a *Album) publish(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	a.subMu.Lock()
+	ids := make([]int, 0, len(a.subscribers))
+	for id := range a.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fns := make([]func(Event), len(ids))
+	for i, id := range ids {
+		fns[i] = a.subscribers[id]
+	}
+	a.subMu.Unlock()
+
+	for _, e := range events {
+		for _, fn := range fns {
+			fn(e)
+		}
+	}
+}
+
+// diffPhotos returns the events which turn before into after, ordered by
+// filename.
+func diffPhotos(before, after map[string]Photo, now time.Time) []Event {
+	var events []Event
+	for name, p := range after {
+		if old, ok := before[name]; !ok {
+			events = append(events, Event{Type: PhotoAdded, Time: now, Photo: p})
+		} else if old.Hash != p.Hash {
+			events = append(events, Event{Type: PhotoUpdated, Time: now, Photo: p})
+		}
+	}
+	for name, p := range before {
+		if _, ok := after[name]; !ok {
+			events = append(events, Event{Type: PhotoRemoved, Time: now, Photo: p})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Photo.Filename < events[j].Photo.Filename
+	})
+	return events
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"testing"
+
+	"github.com/dpup/dbps/internal/dropbox/dropboxtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlbum_Subscribe(t *testing.T) {
+	s := dropboxtest.NewServer()
+	defer s.Close()
+	s.Put("/photos/a.jpg", testJPEG(t, 20, 10))
+	s.Put("/photos/b.jpg", testJPEG(t, 20, 10))
+	s.Put("/photos/c.jpg", testJPEG(t, 20, 10))
+
+	a, _ := newTestAlbum(t, s, 100)
+	var events []Event
+	cancel := a.Subscribe(func(e Event) {
+		events = append(events, e)
+	})
+	next := changes(a)
+
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"added a.jpg", "added b.jpg", "added c.jpg"}, next())
+
+	old, _ := a.lookup("b.jpg")
+	s.Put("/photos/a.jpg", testJPEG(t, 30, 10))
+	s.Remove("/photos/b.jpg")
+	s.Put("/photos/d.jpg", testJPEG(t, 20, 10))
+	s.Move("/photos/c.jpg", "/photos/e.jpg")
+	events = nil
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{
+		"updated a.jpg",
+		"removed b.jpg",
+		"removed c.jpg",
+		"added d.jpg",
+		"added e.jpg",
+	}, next())
+
+	// Removals carry the old photo, and the others the new one.
+	if assert.Equal(t, 5, len(events)) {
+		assert.Equal(t, old, events[1].Photo)
+		p, _ := a.lookup("a.jpg")
+		assert.Equal(t, p, events[0].Photo)
+		assert.False(t, events[0].Time.IsZero())
+	}
+
+	// Loads that find nothing publish nothing.
+	assert.NoError(t, a.Load())
+	assert.Nil(t, next())
+
+	s.FailNext(1, 400)
+	assert.Error(t, a.Load())
+	if assert.Equal(t, 1, len(next())) {
+		assert.Equal(t, LoadFailed, events[len(events)-1].Type)
+		assert.Error(t, events[len(events)-1].Err)
+	}
+
+	// Cancelled subscribers stop receiving events, while others carry on.
+	cancel()
+	events = nil
+	s.Remove("/photos/d.jpg")
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"removed d.jpg"}, next())
+	assert.Equal(t, 0, len(events))
+}
@@ -4,25 +4,136 @@ package dbps
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// Writes the photo data as JSON.
+// Writes the photo data as JSON. The response is cached until the album
+// changes, along with a gzipped copy, and an ETag derived from the content
+// allows clients to revalidate with If-None-Match.
+//
+// Query params filter, sort and page the photos, as described by photoQuery.
+// Pages include a Next cursor when there are more photos. Queried responses
+// aren't cached, but other params are ignored so still get the cached response.
 type jsonHandler struct {
 	album *Album
+
+	cache *jsonResponse
+	mu    sync.Mutex
+}
+
+// jsonResponse is the serialized photo list for a version of the album.
+type jsonResponse struct {
+	version  uint64
+	body     []byte
+	gzipped  []byte
+	etag     string
+	modified time.Time
+}
+
+// jsonPhotos is the JSON written by the handler.
+type jsonPhotos struct {
+	Photos photoList
+	Next   string `json:",omitempty"`
 }
 
 func (j *jsonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var res *jsonResponse
+	if v := r.URL.Query(); hasPhotoQuery(v) {
+		q, err := parsePhotoQuery(v)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		photos, version, modified := j.album.versionedPhotos()
+		page, next := q.apply(photos)
+		res = newJSONResponse(version, modified, jsonPhotos{Photos: page, Next: next})
+	} else {
+		res = j.response()
+	}
+
 	w.Header().Add("Cache-Control", "max-age=180, public, must-revalidate, proxy-revalidate")
 	w.Header().Add("Content-Type", "application/json; charset=utf-8")
-	js, _ := json.Marshal(struct {
-		Photos photoList
-	}{
-		Photos: j.album.Photos(),
-	})
-	w.Write(js)
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	body, etag := res.body, res.etag
+	if res.gzipped != nil && acceptsGzip(r) {
+		// Each encoding is a different representation, so needs its own ETag.
+		body, etag = res.gzipped, etag[:len(etag)-1]+`-gz"`
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("ETag", etag)
+
+	http.ServeContent(w, r, "", res.modified, bytes.NewReader(body))
+}
+
+// response returns the serialized photos, marshaling them again if the album
+// has changed since the last request.
+func (j *jsonHandler) response() *jsonResponse {
+	photos, version, modified := j.album.versionedPhotos()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cache != nil && j.cache.version == version {
+		return j.cache
+	}
+
+	j.cache = newJSONResponse(version, modified, jsonPhotos{Photos: photos})
+	return j.cache
+}
+
+// newJSONResponse serializes v, along with a gzipped copy and its ETag.
+func newJSONResponse(version uint64, modified time.Time, v interface{}) *jsonResponse {
+	js, _ := json.Marshal(v)
+	sum := sha256.Sum256(js)
+	res := &jsonResponse{
+		version:  version,
+		body:     js,
+		etag:     `"` + hex.EncodeToString(sum[:16]) + `"`,
+		modified: modified,
+	}
+	if res.modified.IsZero() {
+		res.modified = time.Now()
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(js)
+	if err := gz.Close(); err == nil {
+		res.gzipped = buf.Bytes()
+	}
+	return res
+}
+
+// acceptsGzip returns true if the client accepts gzip encoded responses. A
+// quality of zero, e.g. "gzip;q=0.0", means the client refuses it.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(enc, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "gzip") {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && strings.EqualFold(param[:2], "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		return q > 0
+	}
+	return false
 }
 
 // Writes an image to the response.
@@ -70,6 +181,73 @@ func (p *thumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, photo.Filename, photo.DropboxModified, bytes.NewReader(data))
 }
 
+// pingInterval is how long the health handler reuses the result of checking
+// whether the source is reachable, so that frequent probes don't hit Dropbox.
+const pingInterval = 30 * time.Second
+
+// Reports the album's status as JSON, with a 503 until the album has photos to
+// serve. That's once the first Load succeeds or, if a snapshot was restored,
+// straight away, as the snapshot's photos are served while the album syncs.
+type healthHandler struct {
+	album *Album
+
+	pinged  time.Time
+	pingErr error
+	mu      sync.Mutex
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st := h.album.Status()
+	out := struct {
+		Ready     bool
+		Restored  bool
+		LastLoad  *time.Time `json:",omitempty"`
+		Failures  int
+		Backoff   string
+		Photos    int
+		Reachable *bool  `json:",omitempty"`
+		Error     string `json:",omitempty"`
+	}{
+		Ready:    st.Ready,
+		Restored: st.Restored,
+		Failures: st.Failures,
+		Backoff:  st.Backoff.String(),
+		Photos:   st.Photos,
+	}
+	if !st.LastLoad.IsZero() {
+		out.LastLoad = &st.LastLoad
+	}
+	if st.Err != nil {
+		out.Error = st.Err.Error()
+	}
+	if p, ok := h.album.source.(Pinger); ok {
+		reachable := h.ping(r.Context(), p) == nil
+		out.Reachable = &reachable
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if !st.Ready && !st.Restored {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	js, _ := json.Marshal(out)
+	w.Write(js)
+}
+
+// ping checks the source, reusing the last result for pingInterval.
+func (h *healthHandler) ping(ctx context.Context, p Pinger) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.pinged) < pingInterval {
+		return h.pingErr
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	h.pingErr = p.Ping(ctx)
+	h.pinged = time.Now()
+	return h.pingErr
+}
+
 func getSizeParam(value string, d uint) (uint, error) {
 	if value == "" {
 		return d, nil
@@ -0,0 +1,145 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// health returns the status code and JSON from the health handler.
+func health(a *Album) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	(&healthHandler{album: a}).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	var out map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &out)
+	return w.Code, out
+}
+
+func TestHealthHandler(t *testing.T) {
+	a := NewAlbum(t.Name(), &memSource{})
+	a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	code, out := health(a)
+	assert.Equal(t, 503, code)
+	assert.Equal(t, false, out["Ready"])
+
+	assert.NoError(t, a.Load())
+	code, out = health(a)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, out["Ready"])
+	assert.Equal(t, false, out["Restored"])
+}
+
+func TestHealthHandler_snapshot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snapshot")
+	a := NewAlbum(t.Name(), &memSource{})
+	a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	assert.NoError(t, a.UseSnapshot(filename))
+	assert.NoError(t, a.Load())
+
+	// A restored album is ready to serve before it has synced.
+	b := NewAlbum(t.Name()+"/restored", &memSource{})
+	assert.NoError(t, b.UseSnapshot(filename))
+	code, out := health(b)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, false, out["Ready"])
+	assert.Equal(t, true, out["Restored"])
+	assert.Equal(t, float64(1), out["Photos"])
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.5", true},
+		{"gzip; q=1.0, br", true},
+		{"br, gzip;q=0.001", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0.0", false},
+		{"gzip; q=0.000", false},
+		{"gzip;Q=0", false},
+		{"gzip;q=nope", false},
+		{"x-gzip2", false},
+		{"deflate, br", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/photos.json", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		assert.Equal(t, tt.want, acceptsGzip(r), tt.header)
+	}
+}
+
+func TestJSONHandler_etag(t *testing.T) {
+	a := NewAlbum(t.Name(), &memSource{})
+	a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	assert.NoError(t, a.Load())
+	j := &jsonHandler{album: a}
+
+	get := func(encoding, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/photos.json", nil)
+		if encoding != "" {
+			r.Header.Set("Accept-Encoding", encoding)
+		}
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		j.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Contains(t, w.Body.String(), `"Filename":"a.jpg"`)
+	plain := w.Header().Get("ETag")
+	body := w.Body.String()
+
+	// The gzipped representation has its own ETag.
+	w = get("gzip", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	gzipped := w.Header().Get("ETag")
+	assert.Equal(t, plain[:len(plain)-1]+`-gz"`, gzipped)
+	gz, err := gzip.NewReader(w.Body)
+	if assert.NoError(t, err) {
+		data, _ := ioutil.ReadAll(gz)
+		assert.Equal(t, body, string(data))
+	}
+
+	w = get("gzip;q=0", "")
+	assert.Equal(t, plain, w.Header().Get("ETag"))
+
+	tests := []struct {
+		encoding string
+		etag     string
+		code     int
+	}{
+		{"", plain, 304},
+		{"gzip", gzipped, 304},
+		{"", gzipped, 200},
+		{"gzip", plain, 200},
+		{"", `"other"`, 200},
+		{"gzip", `"other", ` + gzipped, 304},
+	}
+	for _, tt := range tests {
+		w = get(tt.encoding, tt.etag)
+		assert.Equal(t, tt.code, w.Code, tt.encoding+" "+tt.etag)
+		if tt.code == 304 {
+			assert.Equal(t, 0, w.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package dropbox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dpup/dbps/internal/x/oauth2"
+)
+
+// AuthCodeInput request input.
+type AuthCodeInput struct {
+	RedirectURL string
+	State       string
+	Verifier    string
+}
+
+// AuthCodeURL returns the page that asks a user to authorize the app, for the
+// authorization code flow with PKCE. Offline access is requested, so that the
+// code can be exchanged for a refresh token.
+func (c *Config) AuthCodeURL(in *AuthCodeInput) string {
+	conf := c.oauthConfig()
+	conf.RedirectURL = in.RedirectURL
+	sum := sha256.Sum256([]byte(in.Verifier))
+	return conf.AuthCodeURL(in.State,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("token_access_type", "offline"))
+}
+
+// ExchangeCodeInput request input.
+type ExchangeCodeInput struct {
+	Code        string
+	RedirectURL string
+	Verifier    string
+}
+
+// ExchangeCodeOutput request output.
+type ExchangeCodeOutput struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    uint64 `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	AccountID    string `json:"account_id"`
+	UID          string `json:"uid"`
+}
+
+// ExchangeCode exchanges the code the user was redirected back with for tokens.
+// The verifier must be the one given to AuthCodeURL.
+func (c *Config) ExchangeCode(ctx context.Context, in *ExchangeCodeInput) (out *ExchangeCodeOutput, err error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {in.Code},
+		"redirect_uri":  {in.RedirectURL},
+		"code_verifier": {in.Verifier},
+	}
+	if c.AppSecret == "" {
+		form.Set("client_id", c.AppKey)
+	}
+
+	endpoint := baseURL(c.TokenURL, DefaultTokenURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.AppSecret != "" {
+		req.SetBasicAuth(c.AppKey, c.AppSecret)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		err = &Error{
+			Status:     http.StatusText(res.StatusCode),
+			StatusCode: res.StatusCode,
+			Summary:    strings.TrimSuffix(e.Error+": "+e.Description, ": "),
+		}
+		return
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&out)
+	return
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
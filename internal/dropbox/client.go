@@ -2,11 +2,17 @@ package dropbox
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Client implements a Dropbox client. You may use the Files and Users
@@ -16,6 +22,9 @@ type Client struct {
 	Users   *Users
 	Files   *Files
 	Sharing *Sharing
+
+	once sync.Once
+	hc   *http.Client
 }
 
 // New client.
@@ -28,51 +37,141 @@ func New(config *Config) *Client {
 }
 
 // call rpc style endpoint.
-func (c *Client) call(path string, in interface{}) (io.ReadCloser, error) {
-	url := "https://api.dropboxapi.com/2" + path
+func (c *Client) call(ctx context.Context, path string, in interface{}) (io.ReadCloser, error) {
+	url := baseURL(c.APIURL, DefaultAPIURL) + path
 
 	body, err := json.Marshal(in)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	c.authorize(req)
 	req.Header.Set("Content-Type", "application/json")
 
-	r, _, err := c.do(req)
+	r, _, err := c.do(c.httpClient(), path, req)
 	return r, err
 }
 
-// download style endpoint.
-func (c *Client) download(path string, in interface{}, r io.Reader) (io.ReadCloser, int64, error) {
-	url := "https://content.dropboxapi.com/2" + path
+// notify style endpoint, these don't require authentication and Dropbox rejects
+// requests which include it, so they're sent without the auth transport.
+func (c *Client) notify(ctx context.Context, path string, in interface{}) (io.ReadCloser, error) {
+	url := baseURL(c.NotifyURL, DefaultNotifyURL) + path
 
 	body, err := json.Marshal(in)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+
+	r, _, err := c.do(c.plainClient(), path, req)
+	return r, err
+}
 
-	req, err := http.NewRequest("POST", url, r)
+// download style endpoint.
+func (c *Client) download(ctx context.Context, path string, in interface{}, r io.Reader) (io.ReadCloser, int64, error) {
+	req, err := c.downloadRequest(ctx, path, in, r)
 	if err != nil {
 		return nil, 0, err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	return c.do(c.httpClient(), path, req)
+}
+
+// downloadRequest returns the request for a download style endpoint, so that
+// callers can add extra headers.
+func (c *Client) downloadRequest(ctx context.Context, path string, in interface{}, r io.Reader) (*http.Request, error) {
+	url := baseURL(c.ContentURL, DefaultContentURL) + path
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, r)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
 	req.Header.Set("Dropbox-API-Arg", string(body))
 
 	if r != nil {
 		req.Header.Set("Content-Type", "application/octet-stream")
 	}
 
-	return c.do(req)
+	return req, nil
 }
 
-// perform the request.
-func (c *Client) do(req *http.Request) (io.ReadCloser, int64, error) {
-	res, err := c.HTTPClient.Do(req)
+// authorize adds the access token to the request. Clients using a refresh token
+// add it in their transport instead.
+func (c *Client) authorize(req *http.Request) {
+	if c.RefreshToken == "" {
+		req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	}
+}
+
+// plainClient returns the configured client, which doesn't add an access token.
+func (c *Client) plainClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// httpClient returns the client used to send authenticated requests.
+func (c *Client) httpClient() *http.Client {
+	c.once.Do(func() {
+		c.hc = c.plainClient()
+		if c.RefreshToken != "" {
+			c.hc = c.authClient()
+		}
+	})
+	return c.hc
+}
+
+// perform the request with hc, retrying transient failures.
+func (c *Client) do(hc *http.Client, path string, req *http.Request) (r io.ReadCloser, n int64, err error) {
+	if c.Observer != nil {
+		defer func(start time.Time) {
+			c.Observer(strings.TrimPrefix(path, "/"), time.Since(start), err)
+		}(time.Now())
+	}
+
+	var reason string
+	for retries := 0; ; retries++ {
+		r, n, err = c.doOnce(hc, req)
+		if err == nil {
+			return r, n, nil
+		}
+
+		ctx := req.Context()
+		why, wait := retryReason(ctx, err)
+		if why == "" || retries >= c.MaxRetries || !rewind(req) {
+			return nil, 0, retryError(err, retries, reason)
+		}
+		reason = why
+
+		if wait <= 0 {
+			wait = backoff(c.RetryBackoff, retries)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, 0, retryError(ctx.Err(), retries, reason)
+		}
+	}
+}
+
+// doOnce performs the request a single time.
+func (c *Client) doOnce(hc *http.Client, req *http.Request) (io.ReadCloser, int64, error) {
+	res, err := hc.Do(req)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -88,6 +187,10 @@ func (c *Client) do(req *http.Request) (io.ReadCloser, int64, error) {
 		StatusCode: res.StatusCode,
 	}
 
+	if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+
 	kind := res.Header.Get("Content-Type")
 
 	if strings.Contains(kind, "text/plain") {
@@ -104,3 +207,63 @@ func (c *Client) do(req *http.Request) (io.ReadCloser, int64, error) {
 
 	return nil, 0, e
 }
+
+// retryReason returns why a failed request should be retried, and how long the
+// server asked us to wait, or an empty reason if it shouldn't be.
+func retryReason(ctx context.Context, err error) (string, time.Duration) {
+	if ctx.Err() != nil {
+		return "", 0
+	}
+	var e *Error
+	switch {
+	case !errors.As(err, &e):
+		return "network", 0
+	case e.StatusCode == http.StatusTooManyRequests || strings.HasPrefix(e.Summary, "too_many_requests"):
+		return "too_many_requests", e.RetryAfter
+	case e.StatusCode >= 500:
+		return "server_error", e.RetryAfter
+	}
+	return "", 0
+}
+
+// rewind resets the request body so it can be sent again, returning false if
+// that isn't possible, e.g. for uploads streamed from a reader.
+func rewind(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
+
+// backoff returns the delay before the given retry, doubling each time with
+// up to 50% jitter so that concurrent requests don't retry in lockstep.
+func backoff(base time.Duration, retries int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base << uint(retries)
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// retryError records the retries made on the error returned to the caller.
+// Errors which didn't come from Dropbox are wrapped if any retries were made.
+func retryError(err error, retries int, reason string) error {
+	if retries == 0 {
+		return err
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Summary: err.Error(), Err: err}
+	}
+	e.Retries = retries
+	e.RetryReason = reason
+	return e
+}
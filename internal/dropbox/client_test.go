@@ -30,7 +30,7 @@ func TestClient_error_text(t *testing.T) {
 func TestClient_error_json(t *testing.T) {
 	c := client()
 
-	_, err := c.Files.Download(&DownloadInput{"/nothing"})
+	_, err := c.Files.Download(&DownloadInput{Path: "/nothing"})
 	assert.Error(t, err)
 
 	e := err.(*Error)
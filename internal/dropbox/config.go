@@ -2,18 +2,81 @@ package dropbox
 
 import (
 	"net/http"
+	"time"
+)
+
+// Default base URLs for the Dropbox API.
+const (
+	DefaultAPIURL     = "https://api.dropboxapi.com/2"
+	DefaultContentURL = "https://content.dropboxapi.com/2"
+	DefaultNotifyURL  = "https://notify.dropboxapi.com/2"
+	DefaultAuthURL    = "https://www.dropbox.com/oauth2/authorize"
+	DefaultTokenURL   = "https://api.dropboxapi.com/oauth2/token"
 )
 
 // Config for the Dropbox clients.
 type Config struct {
 	HTTPClient  *http.Client
 	AccessToken string
+
+	// AppKey, AppSecret and RefreshToken let the client fetch short lived access
+	// tokens itself, refreshing them as they expire. If RefreshToken is set then
+	// AccessToken is ignored.
+	AppKey       string
+	AppSecret    string
+	RefreshToken string
+
+	// Base URLs for rpc, content and notify style endpoints, and the OAuth2
+	// authorization and token URLs. These only need to be changed when talking
+	// to something other than Dropbox, such as a fake server in tests.
+	APIURL     string
+	ContentURL string
+	NotifyURL  string
+	AuthURL    string
+	TokenURL   string
+
+	// MaxRetries is how many times a request is retried after a network error,
+	// a server error or being rate limited. Zero disables retries.
+	MaxRetries int
+
+	// RetryBackoff is the delay before the first retry, doubling for each retry
+	// after that. A Retry-After header from the server takes precedence.
+	RetryBackoff time.Duration
+
+	// Observer, if set, is called after each request with the endpoint, such as
+	// "files/list_folder", how long it took including retries, and the error if
+	// it failed. Reading the body of a download isn't included.
+	Observer func(endpoint string, d time.Duration, err error)
 }
 
 // NewConfig with the given access token.
 func NewConfig(accessToken string) *Config {
 	return &Config{
-		HTTPClient:  http.DefaultClient,
-		AccessToken: accessToken,
+		HTTPClient:   http.DefaultClient,
+		AccessToken:  accessToken,
+		APIURL:       DefaultAPIURL,
+		ContentURL:   DefaultContentURL,
+		NotifyURL:    DefaultNotifyURL,
+		AuthURL:      DefaultAuthURL,
+		TokenURL:     DefaultTokenURL,
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}
+}
+
+// NewRefreshConfig for an app authorized with a refresh token.
+func NewRefreshConfig(appKey, appSecret, refreshToken string) *Config {
+	c := NewConfig("")
+	c.AppKey = appKey
+	c.AppSecret = appSecret
+	c.RefreshToken = refreshToken
+	return c
+}
+
+// baseURL returns url, or the default if it hasn't been set.
+func baseURL(url, d string) string {
+	if url == "" {
+		return d
 	}
+	return url
 }
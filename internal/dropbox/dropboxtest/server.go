@@ -0,0 +1,879 @@
+// Package dropboxtest provides an in-memory fake of the Dropbox API, for
+// testing code that uses the dropbox package without a network connection.
+package dropboxtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/gif"
+
+	"github.com/dpup/dbps/internal/dropbox"
+	"github.com/dpup/dbps/internal/resize"
+)
+
+// Server is a fake Dropbox API backed by an in-memory file tree. The same
+// server handles rpc, content and notify style endpoints.
+type Server struct {
+	*httptest.Server
+
+	// Token, if set, must be sent as the bearer token on authenticated calls.
+	Token string
+
+	// RefreshToken, if set, can be exchanged for access tokens at the OAuth2
+	// token endpoint. Issued tokens expire after TokenLifetime.
+	RefreshToken  string
+	TokenLifetime time.Duration
+
+	mu       sync.Mutex
+	files    map[string]*file
+	log      []string
+	changed  chan struct{}
+	closed   chan struct{}
+	cursors  map[string]*cursor
+	links    map[string][]dropbox.SharedLinkOutput
+	nextID   int
+	resetGen int
+	failures []int
+	issued   map[string]time.Time
+	grants   map[string]grant
+}
+
+// grant is an authorization code waiting to be exchanged.
+type grant struct {
+	redirect  string
+	challenge string
+}
+
+// file is a file or folder in the tree, keyed by its lower cased path.
+type file struct {
+	meta dropbox.Metadata
+	data []byte
+}
+
+// cursor is the server side state for a list_folder cursor.
+type cursor struct {
+	path      string
+	recursive bool
+	mediaInfo bool
+	limit     int
+	pos       int
+	pending   []*dropbox.Metadata
+	gen       int
+}
+
+// NewServer starts a fake server with an empty Dropbox.
+func NewServer() *Server {
+	s := &Server{
+		files:   make(map[string]*file),
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+		cursors: make(map[string]*cursor),
+		links:   make(map[string][]dropbox.SharedLinkOutput),
+		issued:  make(map[string]time.Time),
+		grants:  make(map[string]grant),
+
+		TokenLifetime: 4 * time.Hour,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/2/files/list_folder", s.auth(s.listFolder))
+	mux.HandleFunc("/2/files/list_folder/continue", s.auth(s.listFolderContinue))
+	mux.HandleFunc("/2/files/list_folder/longpoll", s.listFolderLongpoll)
+	mux.HandleFunc("/2/files/get_metadata", s.auth(s.getMetadata))
+	mux.HandleFunc("/2/files/delete", s.auth(s.delete))
+	mux.HandleFunc("/2/files/download", s.auth(s.download))
+	mux.HandleFunc("/2/files/upload", s.auth(s.upload))
+	mux.HandleFunc("/2/files/get_thumbnail", s.auth(s.getThumbnail))
+	mux.HandleFunc("/2/sharing/create_shared_link_with_settings", s.auth(s.createSharedLink))
+	mux.HandleFunc("/2/sharing/list_shared_links", s.auth(s.listSharedLinks))
+	mux.HandleFunc("/2/sharing/list_folders", s.auth(s.listSharedFolders))
+	mux.HandleFunc("/2/sharing/list_folders/continue", s.auth(s.listSharedFolders))
+	mux.HandleFunc("/2/users/get_account", s.auth(s.getAccount))
+	mux.HandleFunc("/2/users/get_current_account", s.auth(s.getCurrentAccount))
+	mux.HandleFunc("/2/users/get_space_usage", s.auth(s.getSpaceUsage))
+	mux.HandleFunc("/oauth2/authorize", s.authorize)
+	mux.HandleFunc("/oauth2/token", s.token)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unknown API function: "+r.URL.Path, http.StatusNotFound)
+	})
+
+	s.Server = httptest.NewServer(s.failing(mux))
+	return s
+}
+
+// Close releases any pending long polls and shuts down the server.
+func (s *Server) Close() {
+	close(s.closed)
+	s.Server.Close()
+}
+
+// Config returns a client config which talks to the fake server.
+func (s *Server) Config() *dropbox.Config {
+	c := dropbox.NewConfig(s.Token)
+	c.HTTPClient = s.Server.Client()
+	c.APIURL = s.URL + "/2"
+	c.ContentURL = s.URL + "/2"
+	c.NotifyURL = s.URL + "/2"
+	c.AuthURL = s.URL + "/oauth2/authorize"
+	c.TokenURL = s.URL + "/oauth2/token"
+	return c
+}
+
+// FailNext makes the next n requests fail with the given status. Rate limited
+// responses, with status 429, are returned as too_many_requests errors.
+func (s *Server) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// TokensIssued returns how many access tokens have been issued for the refresh
+// token.
+func (s *Server) TokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.issued)
+}
+
+// Put adds or replaces a file, creating any missing parent folders.
+func (s *Server) Put(p string, data []byte) *dropbox.Metadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.put(p, data, time.Time{})
+	return &m
+}
+
+// SetMediaInfo sets the media_info returned for a file when listing folders
+// with IncludeMediaInfo. This is recorded as a change to the file.
+func (s *Server) SetMediaInfo(p string, info *dropbox.MediaInfo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[strings.ToLower(p)]
+	if !ok || f.meta.Tag != "file" {
+		return false
+	}
+	f.meta.MediaInfo = info
+	s.record(f.meta.PathLower)
+	return true
+}
+
+// Remove deletes a file or folder and its contents, returning false if nothing
+// exists at the path.
+func (s *Server) Remove(p string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.remove(p)
+	return ok
+}
+
+// Move renames a file, returning false if it doesn't exist.
+func (s *Server) Move(from, to string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[strings.ToLower(from)]
+	if !ok || f.meta.Tag != "file" {
+		return false
+	}
+	s.remove(from)
+	s.put(to, f.data, f.meta.ClientModified)
+	return true
+}
+
+// File returns the contents of a file.
+func (s *Server) File(p string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[strings.ToLower(p)]
+	if !ok || f.meta.Tag != "file" {
+		return nil, false
+	}
+	return f.data, true
+}
+
+// ResetCursors invalidates every cursor that has been issued, so that clients
+// must list their folders again from scratch.
+func (s *Server) ResetCursors() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetGen++
+	s.notify()
+}
+
+// put stores a file and records the change. Callers must hold the lock.
+func (s *Server) put(p string, data []byte, clientModified time.Time) dropbox.Metadata {
+	now := time.Now().UTC().Truncate(time.Second)
+	if clientModified.IsZero() {
+		clientModified = now
+	}
+	hash, _ := dropbox.ContentHash(bytes.NewReader(data))
+
+	s.mkdirs(path.Dir(p))
+	s.nextID++
+	f := &file{
+		meta: dropbox.Metadata{
+			Tag:            "file",
+			Name:           path.Base(p),
+			PathLower:      strings.ToLower(p),
+			PathDisplay:    p,
+			ClientModified: clientModified,
+			ServerModified: now,
+			Rev:            fmt.Sprintf("%09x", s.nextID),
+			Size:           uint64(len(data)),
+			ID:             fmt.Sprintf("id:%d", s.nextID),
+			ContentHash:    hash,
+		},
+		data: data,
+	}
+	s.files[f.meta.PathLower] = f
+	s.record(f.meta.PathLower)
+	return f.meta
+}
+
+// mkdirs creates a folder and its parents. Callers must hold the lock.
+func (s *Server) mkdirs(p string) {
+	if p == "/" || p == "." || p == "" {
+		return
+	}
+	if _, ok := s.files[strings.ToLower(p)]; ok {
+		return
+	}
+	s.mkdirs(path.Dir(p))
+	s.nextID++
+	s.files[strings.ToLower(p)] = &file{meta: dropbox.Metadata{
+		Tag:         "folder",
+		Name:        path.Base(p),
+		PathLower:   strings.ToLower(p),
+		PathDisplay: p,
+		ID:          fmt.Sprintf("id:%d", s.nextID),
+	}}
+	s.record(strings.ToLower(p))
+}
+
+// remove deletes a file or folder, and its contents. Callers must hold the lock.
+func (s *Server) remove(p string) (dropbox.Metadata, bool) {
+	lower := strings.ToLower(p)
+	f, ok := s.files[lower]
+	if !ok {
+		return dropbox.Metadata{}, false
+	}
+	for k := range s.files {
+		if k == lower || strings.HasPrefix(k, lower+"/") {
+			delete(s.files, k)
+			s.record(k)
+		}
+	}
+	return f.meta, true
+}
+
+// record appends a change to the log and wakes up any long polls. Callers must
+// hold the lock.
+func (s *Server) record(lower string) {
+	s.log = append(s.log, lower)
+	s.notify()
+}
+
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// newCursor stores the state and returns its id. Callers must hold the lock.
+func (s *Server) newCursor(c *cursor) string {
+	s.nextID++
+	c.gen = s.resetGen
+	id := fmt.Sprintf("cursor-%d", s.nextID)
+	s.cursors[id] = c
+	return id
+}
+
+// inFolder returns true if lower is an entry which should be listed for c.
+func (c *cursor) inFolder(lower string) bool {
+	if c.recursive {
+		return c.path == "" || strings.HasPrefix(lower, c.path+"/")
+	}
+	return path.Dir(lower) == c.path || (c.path == "" && path.Dir(lower) == "/")
+}
+
+// metadata returns a copy of the file's metadata as it should be listed.
+func (c *cursor) metadata(f *file) *dropbox.Metadata {
+	m := f.meta
+	if !c.mediaInfo {
+		m.MediaInfo = nil
+	}
+	return &m
+}
+
+// page returns the next page of entries for the cursor.
+func (s *Server) page(c *cursor) *dropbox.ListFolderOutput {
+	entries := c.pending
+	next := &cursor{path: c.path, recursive: c.recursive, mediaInfo: c.mediaInfo, limit: c.limit, pos: c.pos}
+	if c.limit > 0 && len(entries) > c.limit {
+		entries, next.pending = entries[:c.limit], entries[c.limit:]
+	}
+	return &dropbox.ListFolderOutput{
+		Cursor:  s.newCursor(next),
+		HasMore: len(next.pending) > 0,
+		Entries: entries,
+	}
+}
+
+// changes returns the entries that have changed since the cursor was issued.
+// Callers must hold the lock.
+func (s *Server) changes(c *cursor) []*dropbox.Metadata {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, p := range s.log[c.pos:] {
+		if c.inFolder(p) && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	var entries []*dropbox.Metadata
+	for _, p := range paths {
+		if f, ok := s.files[p]; ok {
+			entries = append(entries, c.metadata(f))
+		} else {
+			entries = append(entries, &dropbox.Metadata{
+				Tag:         "deleted",
+				Name:        path.Base(p),
+				PathLower:   p,
+				PathDisplay: p,
+			})
+		}
+	}
+	return entries
+}
+
+// lookupCursor returns the state for a cursor, or writes an error.
+func (s *Server) lookupCursor(w http.ResponseWriter, id string) (*cursor, bool) {
+	c, ok := s.cursors[id]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "bad_cursor/")
+		return nil, false
+	}
+	if c.gen != s.resetGen {
+		writeError(w, http.StatusConflict, "reset/")
+		return nil, false
+	}
+	return c, true
+}
+
+func (s *Server) listFolder(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.ListFolderInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	folder := strings.ToLower(in.Path)
+	if folder != "" {
+		if f, ok := s.files[folder]; !ok {
+			writeError(w, http.StatusConflict, "path/not_found/")
+			return
+		} else if f.meta.Tag != "folder" {
+			writeError(w, http.StatusConflict, "path/not_folder/")
+			return
+		}
+	}
+
+	c := &cursor{path: folder, recursive: in.Recursive, mediaInfo: in.IncludeMediaInfo, limit: int(in.Limit), pos: len(s.log)}
+	var keys []string
+	for k := range s.files {
+		if c.inFolder(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c.pending = append(c.pending, c.metadata(s.files[k]))
+	}
+	writeJSON(w, s.page(c))
+}
+
+func (s *Server) listFolderContinue(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.ListFolderContinueInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookupCursor(w, in.Cursor)
+	if !ok {
+		return
+	}
+	if len(c.pending) == 0 {
+		c = &cursor{path: c.path, recursive: c.recursive, mediaInfo: c.mediaInfo, limit: c.limit, pos: len(s.log), pending: s.changes(c)}
+	}
+	writeJSON(w, s.page(c))
+}
+
+func (s *Server) listFolderLongpoll(w http.ResponseWriter, r *http.Request) {
+	// Like Dropbox, reject tokens sent to an endpoint which doesn't use them.
+	if r.Header.Get("Authorization") != "" {
+		writeBadRequest(w, r, errors.New("Your request includes an Authorization header, but this function does not use it."))
+		return
+	}
+
+	var in dropbox.ListFolderLongpollInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+	if in.Timeout == 0 {
+		in.Timeout = 30
+	}
+	timeout := time.After(time.Duration(in.Timeout) * time.Second)
+
+	for {
+		s.mu.Lock()
+		c, ok := s.lookupCursor(w, in.Cursor)
+		if !ok {
+			s.mu.Unlock()
+			return
+		}
+		changes := len(c.pending) > 0 || len(s.changes(c)) > 0
+		changed := s.changed
+		s.mu.Unlock()
+
+		if changes {
+			writeJSON(w, &dropbox.ListFolderLongpollOutput{Changes: true})
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			writeJSON(w, &dropbox.ListFolderLongpollOutput{Changes: false})
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			writeJSON(w, &dropbox.ListFolderLongpollOutput{Changes: false})
+			return
+		}
+	}
+}
+
+func (s *Server) getMetadata(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.GetMetadataInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[strings.ToLower(in.Path)]
+	if !ok {
+		writeError(w, http.StatusConflict, "path/not_found/")
+		return
+	}
+	writeJSON(w, f.meta)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.DeleteInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.remove(in.Path)
+	if !ok {
+		writeError(w, http.StatusConflict, "path_lookup/not_found/")
+		return
+	}
+	writeJSON(w, m)
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.DownloadInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	f, ok := s.files[strings.ToLower(in.Path)]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusConflict, "path/not_found/")
+		return
+	} else if f.meta.Tag != "file" {
+		writeError(w, http.StatusConflict, "path/not_file/")
+		return
+	}
+
+	result, _ := json.Marshal(f.meta)
+	w.Header().Set("Dropbox-API-Result", string(result))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, f.meta.Name, f.meta.ServerModified, bytes.NewReader(f.data))
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.UploadInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var clientModified time.Time
+	if in.ClientModified != "" {
+		clientModified, err = time.Parse(time.RFC3339, in.ClientModified)
+		if err != nil {
+			writeBadRequest(w, r, err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.files[strings.ToLower(in.Path)]; ok && in.Mode != dropbox.WriteModeOverwrite {
+		if f.meta.Tag != "file" || !bytes.Equal(f.data, data) {
+			writeError(w, http.StatusConflict, "path/conflict/file/")
+			return
+		}
+		writeJSON(w, f.meta)
+		return
+	}
+	writeJSON(w, s.put(in.Path, data, clientModified))
+}
+
+func (s *Server) getThumbnail(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.GetThumbnailInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	f, ok := s.files[strings.ToLower(in.Path)]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusConflict, "path/not_found/")
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(f.data))
+	if err != nil {
+		writeError(w, http.StatusConflict, "unsupported_image/")
+		return
+	}
+
+	var width, height uint = 64, 64
+	if in.Size != "" {
+		fmt.Sscanf(string(in.Size), "w%dh%d", &width, &height)
+	}
+	img = resize.Thumbnail(width, height, img, resize.Bilinear)
+
+	result, _ := json.Marshal(f.meta)
+	w.Header().Set("Dropbox-API-Result", string(result))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if in.Format == dropbox.GetThumbnailFormatPNG {
+		png.Encode(w, img)
+	} else {
+		jpeg.Encode(w, img, nil)
+	}
+}
+
+func (s *Server) createSharedLink(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.CreateSharedLinkInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lower := strings.ToLower(in.Path)
+	f, ok := s.files[lower]
+	if !ok {
+		writeError(w, http.StatusConflict, "path/not_found/")
+		return
+	}
+	if len(s.links[lower]) > 0 {
+		writeError(w, http.StatusConflict, "shared_link_already_exists/")
+		return
+	}
+
+	var link dropbox.SharedLinkOutput
+	link.URL = fmt.Sprintf("%s/s/%s/%s?dl=0", s.URL, strings.TrimPrefix(f.meta.ID, "id:"), f.meta.Name)
+	link.Path = f.meta.PathDisplay
+	link.VisibilityModel.Tag = dropbox.Public
+	s.links[lower] = append(s.links[lower], link)
+	writeJSON(w, link)
+}
+
+func (s *Server) listSharedLinks(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.ListShareLinksInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := &dropbox.ListShareLinksOutput{Links: []dropbox.SharedLinkOutput{}}
+	for p, links := range s.links {
+		if in.Path == "" || p == strings.ToLower(in.Path) {
+			out.Links = append(out.Links, links...)
+		}
+	}
+	writeJSON(w, out)
+}
+
+// listSharedFolders always returns an empty list, the fake has no other users
+// to share folders with.
+func (s *Server) listSharedFolders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, &dropbox.ListSharedFolderOutput{Entries: []dropbox.SharedFolderMetadata{}})
+}
+
+const accountID = "dbid:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request) {
+	var in dropbox.GetAccountInput
+	if !decodeArg(w, r, &in) {
+		return
+	}
+	if in.AccountID != accountID {
+		writeError(w, http.StatusConflict, "no_account/")
+		return
+	}
+	out := &dropbox.GetAccountOutput{AccountID: accountID}
+	out.Name.GivenName = "Test"
+	out.Name.Surname = "User"
+	out.Name.FamiliarName = "Test"
+	out.Name.DisplayName = "Test User"
+	writeJSON(w, out)
+}
+
+func (s *Server) getCurrentAccount(w http.ResponseWriter, r *http.Request) {
+	out := &dropbox.GetCurrentAccountOutput{
+		AccountID: accountID,
+		Email:     "test@example.com",
+		Locale:    "en",
+		Country:   "US",
+	}
+	out.Name.GivenName = "Test"
+	out.Name.Surname = "User"
+	out.Name.FamiliarName = "Test"
+	out.Name.DisplayName = "Test User"
+	out.AccountType.Tag = "basic"
+	writeJSON(w, out)
+}
+
+func (s *Server) getSpaceUsage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := &dropbox.GetSpaceUsageOutput{}
+	for _, f := range s.files {
+		out.Used += f.meta.Size
+	}
+	out.Allocation.Used = out.Used
+	out.Allocation.Allocated = 2 << 30
+	writeJSON(w, out)
+}
+
+// failing returns errors queued by FailNext before calling the handler.
+func (s *Server) failing(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		status := 0
+		if len(s.failures) > 0 {
+			status, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		switch {
+		case status == http.StatusTooManyRequests:
+			writeError(w, status, "too_many_requests/")
+		case status != 0:
+			http.Error(w, http.StatusText(status), status)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// auth checks the bearer token before calling the handler.
+func (s *Server) auth(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if summary := s.checkToken(r); summary != "" {
+			writeError(w, http.StatusUnauthorized, summary)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// checkToken returns an error summary if the request's access token isn't
+// valid. Any token is accepted if neither Token or RefreshToken are set.
+func (s *Server) checkToken(r *http.Request) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Token == "" && s.RefreshToken == "" {
+		return ""
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.Token != "" && token == s.Token {
+		return ""
+	}
+	if expiry, ok := s.issued[token]; !ok {
+		return "invalid_access_token/"
+	} else if time.Now().After(expiry) {
+		return "expired_access_token/"
+	}
+	return ""
+}
+
+// authorize approves every request, redirecting straight back to the app with
+// an authorization code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() || q.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	code := fmt.Sprintf("code-%d", s.nextID)
+	s.grants[code] = grant{redirect: redirect.String(), challenge: q.Get("code_challenge")}
+	s.mu.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token issues access tokens in exchange for an authorization code or the
+// refresh token. Exchanging a code issues a refresh token if there isn't one.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := map[string]interface{}{
+		"token_type": "bearer",
+		"expires_in": int(s.TokenLifetime / time.Second),
+		"account_id": "dbid:test",
+	}
+
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		g, ok := s.grants[r.FormValue("code")]
+		delete(s.grants, r.FormValue("code"))
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || g.redirect != r.FormValue("redirect_uri") ||
+			g.challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		if s.RefreshToken == "" {
+			s.RefreshToken = "refresh-token"
+		}
+		out["refresh_token"] = s.RefreshToken
+	case "refresh_token":
+		if s.RefreshToken == "" || r.FormValue("refresh_token") != s.RefreshToken {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+	default:
+		writeOAuthError(w, "unsupported_grant_type")
+		return
+	}
+
+	token := fmt.Sprintf("token-%d", len(s.issued)+1)
+	s.issued[token] = time.Now().Add(s.TokenLifetime)
+	out["access_token"] = token
+	writeJSON(w, out)
+}
+
+// decodeArg reads the request arguments from either the Dropbox-API-Arg header,
+// for content endpoints, or the body. Returns false if an error was written.
+func decodeArg(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	var err error
+	if arg := r.Header.Get("Dropbox-API-Arg"); arg != "" {
+		err = json.Unmarshal([]byte(arg), v)
+	} else if r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(v)
+	}
+	if err != nil {
+		writeBadRequest(w, r, err)
+		return false
+	}
+	return true
+}
+
+// writeBadRequest writes a plain text error, as Dropbox does for malformed
+// calls.
+func writeBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, "Error in call to API function %q: %s", strings.TrimPrefix(r.URL.Path, "/2/"), err)
+}
+
+// writeError writes a Dropbox style error, where the summary is a list of tags
+// separated by slashes, e.g. "path/not_found/".
+func writeError(w http.ResponseWriter, status int, summary string) {
+	tags := strings.Split(strings.Trim(summary, "/"), "/")
+	var inner map[string]interface{}
+	for i := len(tags) - 1; i >= 0; i-- {
+		e := map[string]interface{}{".tag": tags[i]}
+		if inner != nil {
+			e[tags[i]] = inner
+		}
+		inner = e
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error_summary": summary + "..",
+		"error":         inner,
+	})
+}
+
+// writeOAuthError writes an error from the OAuth2 token endpoint.
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
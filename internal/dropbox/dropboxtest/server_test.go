@@ -0,0 +1,463 @@
+package dropboxtest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dpup/dbps/internal/dropbox"
+	"github.com/stretchr/testify/assert"
+)
+
+func client(s *Server) *dropbox.Client {
+	return dropbox.New(s.Config())
+}
+
+func TestServer_ListFolder(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	s.Put("/photos/a.jpg", []byte("a"))
+	s.Put("/photos/b.jpg", []byte("b"))
+	s.Put("/photos/c.jpg", []byte("c"))
+	s.Put("/other/d.jpg", []byte("d"))
+
+	out, err := c.Files.ListFolder(&dropbox.ListFolderInput{Path: "/photos", Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(out.Entries))
+	assert.True(t, out.HasMore)
+	assert.Equal(t, "/photos/a.jpg", out.Entries[0].PathLower)
+
+	out, err = c.Files.ListFolderContinue(&dropbox.ListFolderContinueInput{Cursor: out.Cursor})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(out.Entries))
+	assert.False(t, out.HasMore)
+	assert.Equal(t, "/photos/c.jpg", out.Entries[0].PathLower)
+
+	s.Remove("/photos/a.jpg")
+	s.Put("/photos/b.jpg", []byte("bb"))
+
+	out, err = c.Files.ListFolderContinue(&dropbox.ListFolderContinueInput{Cursor: out.Cursor})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(out.Entries))
+	assert.Equal(t, "deleted", out.Entries[0].Tag)
+	assert.Equal(t, "/photos/a.jpg", out.Entries[0].PathLower)
+	assert.Equal(t, "file", out.Entries[1].Tag)
+	assert.Equal(t, uint64(2), out.Entries[1].Size)
+}
+
+func TestServer_ListFolder_notFound(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	_, err := client(s).Files.ListFolder(&dropbox.ListFolderInput{Path: "/nothing"})
+	assert.Error(t, err)
+
+	e := err.(*dropbox.Error)
+	assert.Contains(t, e.Error(), "path/not_found")
+	assert.Equal(t, 409, e.StatusCode)
+}
+
+func TestServer_ListFolderContinue_reset(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	s.Put("/a.jpg", []byte("a"))
+	out, err := c.Files.ListFolder(&dropbox.ListFolderInput{Path: "/"})
+	assert.NoError(t, err)
+
+	s.ResetCursors()
+	_, err = c.Files.ListFolderContinue(&dropbox.ListFolderContinueInput{Cursor: out.Cursor})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "reset/")
+}
+
+func TestServer_ListFolderLongpoll(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	s.Put("/photos/a.jpg", []byte("a"))
+	out, err := c.Files.ListFolder(&dropbox.ListFolderInput{Path: "/photos"})
+	assert.NoError(t, err)
+
+	poll, err := c.Files.ListFolderLongpoll(&dropbox.ListFolderLongpollInput{Cursor: out.Cursor, Timeout: 1})
+	assert.NoError(t, err)
+	assert.False(t, poll.Changes)
+
+	go s.Put("/photos/b.jpg", []byte("b"))
+	poll, err = c.Files.ListFolderLongpoll(&dropbox.ListFolderLongpollInput{Cursor: out.Cursor, Timeout: 5})
+	assert.NoError(t, err)
+	assert.True(t, poll.Changes)
+}
+
+func TestServer_UploadDownload(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	up, err := c.Files.Upload(&dropbox.UploadInput{
+		Path:   "/Readme.md",
+		Mode:   dropbox.WriteModeAdd,
+		Reader: bytes.NewReader([]byte("hello")),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/readme.md", up.PathLower)
+
+	hash, _ := dropbox.ContentHash(bytes.NewReader([]byte("hello")))
+	assert.Equal(t, hash, up.ContentHash)
+
+	out, err := c.Files.Download(&dropbox.DownloadInput{Path: "/readme.md"})
+	assert.NoError(t, err)
+	defer out.Body.Close()
+	assert.Equal(t, int64(5), out.Length)
+
+	data, err := ioutil.ReadAll(out.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	_, err = c.Files.Upload(&dropbox.UploadInput{
+		Path:   "/Readme.md",
+		Mode:   dropbox.WriteModeAdd,
+		Reader: bytes.NewReader([]byte("changed")),
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "path/conflict")
+}
+
+func TestServer_GetMetadata(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	s.Put("/photos/a.jpg", []byte("a"))
+
+	out, err := c.Files.GetMetadata(&dropbox.GetMetadataInput{Path: "/photos"})
+	assert.NoError(t, err)
+	assert.Equal(t, "folder", out.Tag)
+
+	out, err = c.Files.GetMetadata(&dropbox.GetMetadataInput{Path: "/photos/a.jpg"})
+	assert.NoError(t, err)
+	assert.Equal(t, "file", out.Tag)
+	assert.Equal(t, "a.jpg", out.Name)
+}
+
+func TestServer_GetThumbnail(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	data, err := ioutil.ReadFile("../../goexif/exif/sample1.jpg")
+	assert.NoError(t, err)
+	s.Put("/sample.jpg", data)
+
+	out, err := client(s).Files.GetThumbnail(&dropbox.GetThumbnailInput{
+		Path:   "/sample.jpg",
+		Format: dropbox.GetThumbnailFormatJPEG,
+		Size:   dropbox.GetThumbnailSizeW32H32,
+	})
+	assert.NoError(t, err)
+	defer out.Body.Close()
+
+	buf := make([]byte, 2)
+	_, err = out.Body.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xd8}, buf, "should have jpeg header")
+}
+
+func TestServer_Sharing(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	s.Put("/hello.txt", []byte("hello"))
+
+	out, err := c.Sharing.CreateSharedLink(&dropbox.CreateSharedLinkInput{Path: "/hello.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/hello.txt", out.Path)
+
+	links, err := c.Sharing.ListSharedLinks(&dropbox.ListShareLinksInput{Path: "/hello.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(links.Links))
+	assert.Equal(t, out.URL, links.Links[0].URL)
+}
+
+func TestServer_Users(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	out, err := c.Users.GetCurrentAccount()
+	assert.NoError(t, err)
+
+	acct, err := c.Users.GetAccount(&dropbox.GetAccountInput{AccountID: out.AccountID})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test User", acct.Name.DisplayName)
+}
+
+func TestServer_Token(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Token = "secret"
+
+	config := s.Config()
+	config.AccessToken = "wrong"
+
+	_, err := dropbox.New(config).Users.GetCurrentAccount()
+	assert.Error(t, err)
+
+	e := err.(*dropbox.Error)
+	assert.Contains(t, e.Error(), "invalid_access_token")
+	assert.Equal(t, 401, e.StatusCode)
+}
+
+func TestServer_DownloadRange(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Put("/hello.txt", []byte("hello world"))
+
+	out, err := client(s).Files.Download(&dropbox.DownloadInput{Path: "/hello.txt", Offset: 6, Length: 3})
+	assert.NoError(t, err)
+	defer out.Body.Close()
+	assert.Equal(t, int64(3), out.Length)
+
+	data, err := ioutil.ReadAll(out.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("wor"), data)
+}
+
+func TestServer_SetMediaInfo(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	s.Put("/photos/a.jpg", []byte("a"))
+	s.SetMediaInfo("/photos/a.jpg", &dropbox.MediaInfo{
+		Metadata: &dropbox.MediaMetadata{
+			Photo: &dropbox.PhotoMetadata{Dimensions: &dropbox.Dimensions{Width: 640, Height: 480}},
+		},
+	})
+
+	out, err := c.Files.ListFolder(&dropbox.ListFolderInput{Path: "/photos"})
+	assert.NoError(t, err)
+	assert.Nil(t, out.Entries[0].MediaInfo)
+
+	out, err = c.Files.ListFolder(&dropbox.ListFolderInput{Path: "/photos", IncludeMediaInfo: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(640), out.Entries[0].MediaInfo.Metadata.Photo.Dimensions.Width)
+}
+
+func TestServer_ListFolderLongpoll_cancel(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := client(s)
+
+	s.Put("/photos/a.jpg", []byte("a"))
+	out, err := c.Files.ListFolder(&dropbox.ListFolderInput{Path: "/photos"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = c.Files.ListFolderLongpollContext(ctx, &dropbox.ListFolderLongpollInput{Cursor: out.Cursor, Timeout: 30})
+	assert.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+}
+
+func TestServer_FailNext_retries(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := s.Config()
+	config.RetryBackoff = time.Millisecond
+	c := dropbox.New(config)
+
+	s.Put("/hello.txt", []byte("hello"))
+	s.FailNext(1, 429)
+	s.FailNext(1, 503)
+
+	out, err := c.Files.GetMetadata(&dropbox.GetMetadataInput{Path: "/hello.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "hello.txt", out.Name)
+
+	s.FailNext(4, 500)
+	_, err = c.Files.GetMetadata(&dropbox.GetMetadataInput{Path: "/hello.txt"})
+	assert.Error(t, err)
+
+	e := err.(*dropbox.Error)
+	assert.Equal(t, 500, e.StatusCode)
+	assert.Equal(t, 3, e.Retries)
+	assert.Equal(t, "server_error", e.RetryReason)
+	assert.Contains(t, e.Error(), "after 3 retries, last for server_error")
+}
+
+func TestServer_FailNext_noRetries(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := s.Config()
+	config.MaxRetries = 0
+	c := dropbox.New(config)
+
+	s.Put("/hello.txt", []byte("hello"))
+	s.FailNext(1, 429)
+
+	_, err := c.Files.GetMetadata(&dropbox.GetMetadataInput{Path: "/hello.txt"})
+	assert.Error(t, err)
+
+	e := err.(*dropbox.Error)
+	assert.Equal(t, 429, e.StatusCode)
+	assert.Contains(t, e.Error(), "too_many_requests")
+	assert.Equal(t, 0, e.Retries)
+}
+
+func TestServer_RefreshToken(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Token = "static"
+	s.RefreshToken = "refresh"
+
+	config := s.Config()
+	config.AccessToken = ""
+	config.AppKey = "key"
+	config.AppSecret = "secret"
+	config.RefreshToken = "refresh"
+	c := dropbox.New(config)
+
+	_, err := c.Users.GetCurrentAccount()
+	assert.NoError(t, err)
+	_, err = c.Users.GetCurrentAccount()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.TokensIssued())
+
+	// Tokens within a few seconds of expiring are refreshed before use.
+	s.TokenLifetime = time.Second
+	c = dropbox.New(config)
+	for i := 0; i < 3; i++ {
+		_, err = c.Users.GetCurrentAccount()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 4, s.TokensIssued())
+}
+
+func TestServer_RefreshToken_invalid(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RefreshToken = "refresh"
+
+	config := s.Config()
+	config.RefreshToken = "wrong"
+
+	_, err := dropbox.New(config).Users.GetCurrentAccount()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid_grant")
+	assert.Equal(t, 0, s.TokensIssued())
+}
+
+func TestServer_AuthCode(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := s.Config()
+	config.AppKey = "key"
+	config.AppSecret = "secret"
+
+	verifier := dropbox.NewVerifier()
+	authURL := config.AuthCodeURL(&dropbox.AuthCodeInput{
+		RedirectURL: "http://example.com/callback",
+		State:       "state",
+		Verifier:    verifier,
+	})
+
+	hc := s.Server.Client()
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := hc.Get(authURL)
+	assert.NoError(t, err)
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "state", callback.Query().Get("state"))
+
+	in := &dropbox.ExchangeCodeInput{
+		Code:        callback.Query().Get("code"),
+		RedirectURL: "http://example.com/callback",
+		Verifier:    "wrong",
+	}
+	_, err = config.ExchangeCode(context.Background(), in)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid_grant")
+
+	// Codes can only be used once, so start again.
+	res, err = hc.Get(authURL)
+	assert.NoError(t, err)
+	res.Body.Close()
+	callback, _ = url.Parse(res.Header.Get("Location"))
+
+	in.Code = callback.Query().Get("code")
+	in.Verifier = verifier
+	out, err := config.ExchangeCode(context.Background(), in)
+	assert.NoError(t, err)
+	assert.Equal(t, s.RefreshToken, out.RefreshToken)
+
+	config.RefreshToken = out.RefreshToken
+	_, err = dropbox.New(config).Users.GetCurrentAccount()
+	assert.NoError(t, err)
+}
+
+func TestServer_Observer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	var endpoints []string
+	var errs []error
+	config := s.Config()
+	config.Observer = func(endpoint string, d time.Duration, err error) {
+		endpoints = append(endpoints, endpoint)
+		errs = append(errs, err)
+	}
+	c := dropbox.New(config)
+
+	s.Put("/hello.txt", []byte("hello"))
+	_, err := c.Files.GetMetadata(&dropbox.GetMetadataInput{Path: "/hello.txt"})
+	assert.NoError(t, err)
+	_, err = c.Files.Download(&dropbox.DownloadInput{Path: "/nothing.txt"})
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"files/get_metadata", "files/download"}, endpoints)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+}
+
+func TestServer_ListFolderLongpoll_refreshToken(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RefreshToken = "refresh"
+
+	config := s.Config()
+	config.RefreshToken = "refresh"
+	c := dropbox.New(config)
+
+	s.Put("/photos/a.jpg", []byte("a"))
+	out, err := c.Files.ListFolder(&dropbox.ListFolderInput{Path: "/photos"})
+	assert.NoError(t, err)
+
+	// The access token used for other calls isn't sent to the notify endpoint.
+	go s.Put("/photos/b.jpg", []byte("b"))
+	poll, err := c.Files.ListFolderLongpoll(&dropbox.ListFolderLongpollInput{Cursor: out.Cursor, Timeout: 5})
+	assert.NoError(t, err)
+	assert.True(t, poll.Changes)
+
+	req, _ := http.NewRequest("POST", s.URL+"/2/files/list_folder/longpoll", bytes.NewReader([]byte(`{"cursor": "`+out.Cursor+`"}`)))
+	req.Header.Set("Authorization", "Bearer token")
+	res, err := s.Client().Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res.Body.Close()
+}
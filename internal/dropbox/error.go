@@ -1,13 +1,39 @@
 package dropbox
 
+import (
+	"fmt"
+	"time"
+)
+
 // Error response.
 type Error struct {
 	Status     string
 	StatusCode int
 	Summary    string `json:"error_summary"`
+
+	// RetryAfter is how long the server asked the client to wait before trying
+	// again, if it did.
+	RetryAfter time.Duration `json:"-"`
+
+	// Retries is how many times the request was retried before giving up, and
+	// RetryReason why the last retry was made, e.g. "too_many_requests".
+	Retries     int    `json:"-"`
+	RetryReason string `json:"-"`
+
+	// Err is the underlying error for failures which didn't come from Dropbox,
+	// such as network errors that persisted after retrying.
+	Err error `json:"-"`
 }
 
-// Error string.
+// Error string, including the retries made before giving up.
 func (e *Error) Error() string {
+	if e.Retries > 0 {
+		return fmt.Sprintf("%s (after %d retries, last for %s)", e.Summary, e.Retries, e.RetryReason)
+	}
 	return e.Summary
 }
+
+// Unwrap returns the underlying error, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -1,6 +1,7 @@
 package dropbox
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -108,7 +109,12 @@ type GetMetadataOutput struct {
 
 // GetMetadata returns the metadata for a file or folder.
 func (c *Files) GetMetadata(in *GetMetadataInput) (out *GetMetadataOutput, err error) {
-	body, err := c.call("/files/get_metadata", in)
+	return c.GetMetadataContext(context.Background(), in)
+}
+
+// GetMetadataContext is like GetMetadata but uses ctx for the request.
+func (c *Files) GetMetadataContext(ctx context.Context, in *GetMetadataInput) (out *GetMetadataOutput, err error) {
+	body, err := c.call(ctx, "/files/get_metadata", in)
 	if err != nil {
 		return
 	}
@@ -132,7 +138,12 @@ type CreateFolderOutput struct {
 
 // CreateFolder creates a folder.
 func (c *Files) CreateFolder(in *CreateFolderInput) (out *CreateFolderOutput, err error) {
-	body, err := c.call("/files/create_folder", in)
+	return c.CreateFolderContext(context.Background(), in)
+}
+
+// CreateFolderContext is like CreateFolder but uses ctx for the request.
+func (c *Files) CreateFolderContext(ctx context.Context, in *CreateFolderInput) (out *CreateFolderOutput, err error) {
+	body, err := c.call(ctx, "/files/create_folder", in)
 	if err != nil {
 		return
 	}
@@ -154,7 +165,12 @@ type DeleteOutput struct {
 
 // Delete a file or folder and its contents.
 func (c *Files) Delete(in *DeleteInput) (out *DeleteOutput, err error) {
-	body, err := c.call("/files/delete", in)
+	return c.DeleteContext(context.Background(), in)
+}
+
+// DeleteContext is like Delete but uses ctx for the request.
+func (c *Files) DeleteContext(ctx context.Context, in *DeleteInput) (out *DeleteOutput, err error) {
+	body, err := c.call(ctx, "/files/delete", in)
 	if err != nil {
 		return
 	}
@@ -171,7 +187,12 @@ type PermanentlyDeleteInput struct {
 
 // PermanentlyDelete a file or folder and its contents.
 func (c *Files) PermanentlyDelete(in *PermanentlyDeleteInput) (err error) {
-	body, err := c.call("/files/delete", in)
+	return c.PermanentlyDeleteContext(context.Background(), in)
+}
+
+// PermanentlyDeleteContext is like PermanentlyDelete but uses ctx for the request.
+func (c *Files) PermanentlyDeleteContext(ctx context.Context, in *PermanentlyDeleteInput) (err error) {
+	body, err := c.call(ctx, "/files/delete", in)
 	if err != nil {
 		return
 	}
@@ -193,7 +214,12 @@ type CopyOutput struct {
 
 // Copy a file or folder to a different location.
 func (c *Files) Copy(in *CopyInput) (out *CopyOutput, err error) {
-	body, err := c.call("/files/copy", in)
+	return c.CopyContext(context.Background(), in)
+}
+
+// CopyContext is like Copy but uses ctx for the request.
+func (c *Files) CopyContext(ctx context.Context, in *CopyInput) (out *CopyOutput, err error) {
+	body, err := c.call(ctx, "/files/copy", in)
 	if err != nil {
 		return
 	}
@@ -216,7 +242,12 @@ type MoveOutput struct {
 
 // Move a file or folder to a different location.
 func (c *Files) Move(in *MoveInput) (out *MoveOutput, err error) {
-	body, err := c.call("/files/move", in)
+	return c.MoveContext(context.Background(), in)
+}
+
+// MoveContext is like Move but uses ctx for the request.
+func (c *Files) MoveContext(ctx context.Context, in *MoveInput) (out *MoveOutput, err error) {
+	body, err := c.call(ctx, "/files/move", in)
 	if err != nil {
 		return
 	}
@@ -239,7 +270,12 @@ type RestoreOutput struct {
 
 // Restore a file to a specific revision.
 func (c *Files) Restore(in *RestoreInput) (out *RestoreOutput, err error) {
-	body, err := c.call("/files/restore", in)
+	return c.RestoreContext(context.Background(), in)
+}
+
+// RestoreContext is like Restore but uses ctx for the request.
+func (c *Files) RestoreContext(ctx context.Context, in *RestoreInput) (out *RestoreOutput, err error) {
+	body, err := c.call(ctx, "/files/restore", in)
 	if err != nil {
 		return
 	}
@@ -267,9 +303,14 @@ type ListFolderOutput struct {
 
 // ListFolder returns the metadata for a file or folder.
 func (c *Files) ListFolder(in *ListFolderInput) (out *ListFolderOutput, err error) {
+	return c.ListFolderContext(context.Background(), in)
+}
+
+// ListFolderContext is like ListFolder but uses ctx for the request.
+func (c *Files) ListFolderContext(ctx context.Context, in *ListFolderInput) (out *ListFolderOutput, err error) {
 	in.Path = normalizePath(in.Path)
 
-	body, err := c.call("/files/list_folder", in)
+	body, err := c.call(ctx, "/files/list_folder", in)
 	if err != nil {
 		return
 	}
@@ -286,7 +327,42 @@ type ListFolderContinueInput struct {
 
 // ListFolderContinue pagenates using the cursor from ListFolder.
 func (c *Files) ListFolderContinue(in *ListFolderContinueInput) (out *ListFolderOutput, err error) {
-	body, err := c.call("/files/list_folder/continue", in)
+	return c.ListFolderContinueContext(context.Background(), in)
+}
+
+// ListFolderContinueContext is like ListFolderContinue but uses ctx for the request.
+func (c *Files) ListFolderContinueContext(ctx context.Context, in *ListFolderContinueInput) (out *ListFolderOutput, err error) {
+	body, err := c.call(ctx, "/files/list_folder/continue", in)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+
+	err = json.NewDecoder(body).Decode(&out)
+	return
+}
+
+// ListFolderLongpollInput request input.
+type ListFolderLongpollInput struct {
+	Cursor  string `json:"cursor"`
+	Timeout uint64 `json:"timeout,omitempty"`
+}
+
+// ListFolderLongpollOutput request output.
+type ListFolderLongpollOutput struct {
+	Changes bool   `json:"changes"`
+	Backoff uint64 `json:"backoff,omitempty"`
+}
+
+// ListFolderLongpoll blocks until there are changes to the folder described by
+// the cursor, or the timeout (in seconds) elapses.
+func (c *Files) ListFolderLongpoll(in *ListFolderLongpollInput) (out *ListFolderLongpollOutput, err error) {
+	return c.ListFolderLongpollContext(context.Background(), in)
+}
+
+// ListFolderLongpollContext is like ListFolderLongpoll but uses ctx for the request.
+func (c *Files) ListFolderLongpollContext(ctx context.Context, in *ListFolderLongpollInput) (out *ListFolderLongpollOutput, err error) {
+	body, err := c.notify(ctx, "/files/list_folder/longpoll", in)
 	if err != nil {
 		return
 	}
@@ -342,13 +418,18 @@ type SearchOutput struct {
 
 // Search for files and folders.
 func (c *Files) Search(in *SearchInput) (out *SearchOutput, err error) {
+	return c.SearchContext(context.Background(), in)
+}
+
+// SearchContext is like Search but uses ctx for the request.
+func (c *Files) SearchContext(ctx context.Context, in *SearchInput) (out *SearchOutput, err error) {
 	in.Path = normalizePath(in.Path)
 
 	if in.Mode == "" {
 		in.Mode = SearchModeFilename
 	}
 
-	body, err := c.call("/files/search", in)
+	body, err := c.call(ctx, "/files/search", in)
 	if err != nil {
 		return
 	}
@@ -375,7 +456,12 @@ type UploadOutput struct {
 
 // Upload a file smaller than 150MB.
 func (c *Files) Upload(in *UploadInput) (out *UploadOutput, err error) {
-	body, _, err := c.download("/files/upload", in, in.Reader)
+	return c.UploadContext(context.Background(), in)
+}
+
+// UploadContext is like Upload but uses ctx for the request.
+func (c *Files) UploadContext(ctx context.Context, in *UploadInput) (out *UploadOutput, err error) {
+	body, _, err := c.download(ctx, "/files/upload", in, in.Reader)
 	if err != nil {
 		return
 	}
@@ -388,6 +474,11 @@ func (c *Files) Upload(in *UploadInput) (out *UploadOutput, err error) {
 // DownloadInput request input.
 type DownloadInput struct {
 	Path string `json:"path"`
+
+	// Offset and Length request part of the file, using a Range header. A Length
+	// of zero reads until the end of the file.
+	Offset int64 `json:"-"`
+	Length int64 `json:"-"`
 }
 
 // DownloadOutput request output.
@@ -396,9 +487,26 @@ type DownloadOutput struct {
 	Length int64
 }
 
-// Download a file.
+// Download a file, or part of a file if a range is specified.
 func (c *Files) Download(in *DownloadInput) (out *DownloadOutput, err error) {
-	body, l, err := c.download("/files/download", in, nil)
+	return c.DownloadContext(context.Background(), in)
+}
+
+// DownloadContext is like Download but uses ctx for the request. The context
+// also applies to reading the body, so must not be cancelled until it's done.
+func (c *Files) DownloadContext(ctx context.Context, in *DownloadInput) (out *DownloadOutput, err error) {
+	req, err := c.downloadRequest(ctx, "/files/download", in, nil)
+	if err != nil {
+		return
+	}
+
+	if in.Length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", in.Offset, in.Offset+in.Length-1))
+	} else if in.Offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", in.Offset))
+	}
+
+	body, l, err := c.do(c.httpClient(), "/files/download", req)
 	if err != nil {
 		return
 	}
@@ -449,7 +557,12 @@ type GetThumbnailOutput struct {
 // GetThumbnail a thumbnail for a file. Currently thumbnails are only generated for the
 // files with the following extensions: png, jpeg, png, tiff, tif, gif and bmp.
 func (c *Files) GetThumbnail(in *GetThumbnailInput) (out *GetThumbnailOutput, err error) {
-	body, l, err := c.download("/files/get_thumbnail", in, nil)
+	return c.GetThumbnailContext(context.Background(), in)
+}
+
+// GetThumbnailContext is like GetThumbnail but uses ctx for the request.
+func (c *Files) GetThumbnailContext(ctx context.Context, in *GetThumbnailInput) (out *GetThumbnailOutput, err error) {
+	body, l, err := c.download(ctx, "/files/get_thumbnail", in, nil)
 	if err != nil {
 		return
 	}
@@ -473,7 +586,12 @@ type GetPreviewOutput struct {
 // files with the following extensions: .doc, .docx, .docm, .ppt, .pps, .ppsx,
 // .ppsm, .pptx, .pptm, .xls, .xlsx, .xlsm, .rtf
 func (c *Files) GetPreview(in *GetPreviewInput) (out *GetPreviewOutput, err error) {
-	body, l, err := c.download("/files/get_preview", in, nil)
+	return c.GetPreviewContext(context.Background(), in)
+}
+
+// GetPreviewContext is like GetPreview but uses ctx for the request.
+func (c *Files) GetPreviewContext(ctx context.Context, in *GetPreviewInput) (out *GetPreviewOutput, err error) {
+	body, l, err := c.download(ctx, "/files/get_preview", in, nil)
 	if err != nil {
 		return
 	}
@@ -496,7 +614,12 @@ type ListRevisionsOutput struct {
 
 // ListRevisions gets the revisions of the specified file.
 func (c *Files) ListRevisions(in *ListRevisionsInput) (out *ListRevisionsOutput, err error) {
-	body, err := c.call("/files/list_revisions", in)
+	return c.ListRevisionsContext(context.Background(), in)
+}
+
+// ListRevisionsContext is like ListRevisions but uses ctx for the request.
+func (c *Files) ListRevisionsContext(ctx context.Context, in *ListRevisionsInput) (out *ListRevisionsOutput, err error) {
+	body, err := c.call(ctx, "/files/list_revisions", in)
 	if err != nil {
 		return
 	}
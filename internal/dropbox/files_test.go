@@ -32,7 +32,7 @@ func TestFiles_Upload(t *testing.T) {
 func TestFiles_Download(t *testing.T) {
 	c := client()
 
-	out, err := c.Files.Download(&DownloadInput{"/Readme.md"})
+	out, err := c.Files.Download(&DownloadInput{Path: "/Readme.md"})
 
 	assert.NoError(t, err, "error downloading")
 	defer out.Body.Close()
@@ -1,6 +1,7 @@
 package dropbox
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 )
@@ -48,7 +49,12 @@ const (
 
 // CreateSharedLink returns a shared link.
 func (c *Sharing) CreateSharedLink(in *CreateSharedLinkInput) (out *CreateSharedLinkOutput, err error) {
-	body, err := c.call("/sharing/create_shared_link_with_settings", in)
+	return c.CreateSharedLinkContext(context.Background(), in)
+}
+
+// CreateSharedLinkContext is like CreateSharedLink but uses ctx for the request.
+func (c *Sharing) CreateSharedLinkContext(ctx context.Context, in *CreateSharedLinkInput) (out *CreateSharedLinkOutput, err error) {
+	body, err := c.call(ctx, "/sharing/create_shared_link_with_settings", in)
 	if err != nil {
 		return
 	}
@@ -80,8 +86,13 @@ type ListShareLinksOutput struct {
 
 // ListSharedLinks gets shared links of input.
 func (c *Sharing) ListSharedLinks(in *ListShareLinksInput) (out *ListShareLinksOutput, err error) {
+	return c.ListSharedLinksContext(context.Background(), in)
+}
+
+// ListSharedLinksContext is like ListSharedLinks but uses ctx for the request.
+func (c *Sharing) ListSharedLinksContext(ctx context.Context, in *ListShareLinksInput) (out *ListShareLinksOutput, err error) {
 	endpoint := "/sharing/list_shared_links"
-	body, err := c.call(endpoint, in)
+	body, err := c.call(ctx, endpoint, in)
 	if err != nil {
 		return
 	}
@@ -110,7 +121,12 @@ type ListSharedFolderOutput struct {
 
 // ListSharedFolders returns the list of all shared folders the current user has access to.
 func (c *Sharing) ListSharedFolders(in *ListSharedFolderInput) (out *ListSharedFolderOutput, err error) {
-	body, err := c.call("/sharing/list_folders", in)
+	return c.ListSharedFoldersContext(context.Background(), in)
+}
+
+// ListSharedFoldersContext is like ListSharedFolders but uses ctx for the request.
+func (c *Sharing) ListSharedFoldersContext(ctx context.Context, in *ListSharedFolderInput) (out *ListSharedFolderOutput, err error) {
+	body, err := c.call(ctx, "/sharing/list_folders", in)
 	if err != nil {
 		return
 	}
@@ -127,7 +143,12 @@ type ListSharedFolderContinueInput struct {
 
 // ListSharedFoldersContinue returns the list of all shared folders the current user has access to.
 func (c *Sharing) ListSharedFoldersContinue(in *ListSharedFolderContinueInput) (out *ListSharedFolderOutput, err error) {
-	body, err := c.call("/sharing/list_folders/continue", in)
+	return c.ListSharedFoldersContinueContext(context.Background(), in)
+}
+
+// ListSharedFoldersContinueContext is like ListSharedFoldersContinue but uses ctx for the request.
+func (c *Sharing) ListSharedFoldersContinueContext(ctx context.Context, in *ListSharedFolderContinueInput) (out *ListSharedFolderOutput, err error) {
+	body, err := c.call(ctx, "/sharing/list_folders/continue", in)
 	if err != nil {
 		return
 	}
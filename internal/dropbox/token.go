@@ -0,0 +1,77 @@
+package dropbox
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dpup/dbps/internal/x/net/context"
+	"github.com/dpup/dbps/internal/x/oauth2"
+)
+
+// oauthConfig returns the OAuth2 config for the app.
+func (c *Config) oauthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.AppKey,
+		ClientSecret: c.AppSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  baseURL(c.AuthURL, DefaultAuthURL),
+			TokenURL: baseURL(c.TokenURL, DefaultTokenURL),
+		},
+	}
+}
+
+// tokenTimeout bounds fetching an access token. Requests wait for the token
+// without their own context, so a hung token endpoint would otherwise block
+// every call indefinitely.
+const tokenTimeout = 30 * time.Second
+
+// authClient returns an HTTP client which adds access tokens obtained with the
+// refresh token to each request, fetching a new one when it expires.
+func (c *Config) authClient() *http.Client {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	tc := &http.Client{
+		Transport:     hc.Transport,
+		CheckRedirect: hc.CheckRedirect,
+		Jar:           hc.Jar,
+		Timeout:       tokenTimeout,
+	}
+	if hc.Timeout > 0 && hc.Timeout < tokenTimeout {
+		tc.Timeout = hc.Timeout
+	}
+	src := &refreshTokenSource{
+		ctx:          context.WithValue(context.Background(), oauth2.HTTPClient, tc),
+		conf:         c.oauthConfig(),
+		refreshToken: c.RefreshToken,
+	}
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, src),
+			Base:   hc.Transport,
+		},
+		CheckRedirect: hc.CheckRedirect,
+		Jar:           hc.Jar,
+		Timeout:       hc.Timeout,
+	}
+}
+
+// refreshTokenSource fetches access tokens using a long lived refresh token.
+// Dropbox doesn't send the refresh token back with each access token, which
+// oauth2's own refresher expects, so the original is used every time.
+type refreshTokenSource struct {
+	ctx          context.Context
+	conf         *oauth2.Config
+	refreshToken string
+}
+
+// Token fetches a new access token. Failures are returned as an *Error so that
+// they aren't mistaken for network errors and retried.
+func (s *refreshTokenSource) Token() (*oauth2.Token, error) {
+	t, err := s.conf.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.refreshToken}).Token()
+	if err != nil {
+		return nil, &Error{Summary: err.Error(), Err: err}
+	}
+	return t, nil
+}
@@ -1,6 +1,7 @@
 package dropbox
 
 import (
+	"context"
 	"encoding/json"
 )
 
@@ -36,7 +37,12 @@ type GetAccountOutput struct {
 
 // GetAccount returns information about a user's account.
 func (c *Users) GetAccount(in *GetAccountInput) (out *GetAccountOutput, err error) {
-	body, err := c.call("/users/get_account", in)
+	return c.GetAccountContext(context.Background(), in)
+}
+
+// GetAccountContext is like GetAccount but uses ctx for the request.
+func (c *Users) GetAccountContext(ctx context.Context, in *GetAccountInput) (out *GetAccountOutput, err error) {
+	body, err := c.call(ctx, "/users/get_account", in)
 	if err != nil {
 		return
 	}
@@ -67,7 +73,12 @@ type GetCurrentAccountOutput struct {
 
 // GetCurrentAccount returns information about the current user's account.
 func (c *Users) GetCurrentAccount() (out *GetCurrentAccountOutput, err error) {
-	body, err := c.call("/users/get_current_account", nil)
+	return c.GetCurrentAccountContext(context.Background())
+}
+
+// GetCurrentAccountContext is like GetCurrentAccount but uses ctx for the request.
+func (c *Users) GetCurrentAccountContext(ctx context.Context) (out *GetCurrentAccountOutput, err error) {
+	body, err := c.call(ctx, "/users/get_current_account", nil)
 	if err != nil {
 		return
 	}
@@ -88,7 +99,12 @@ type GetSpaceUsageOutput struct {
 
 // GetSpaceUsage returns space usage information for the current user's account.
 func (c *Users) GetSpaceUsage() (out *GetSpaceUsageOutput, err error) {
-	body, err := c.call("/users/get_space_usage", nil)
+	return c.GetSpaceUsageContext(context.Background())
+}
+
+// GetSpaceUsageContext is like GetSpaceUsage but uses ctx for the request.
+func (c *Users) GetSpaceUsageContext(ctx context.Context) (out *GetSpaceUsageOutput, err error) {
+	body, err := c.call(ctx, "/users/get_space_usage", nil)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,206 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpup/dbps/internal/dropbox"
+)
+
+// LocalSource serves photos from a directory on the local filesystem, useful for
+// previewing a site before syncing it to Dropbox or for running against
+// fixtures. Only files directly within the directory are listed.
+type LocalSource struct {
+	// PollFreq is how often Wait checks the directory for changes.
+	PollFreq time.Duration
+
+	// WaitTimeout is how long Wait blocks before returning with no changes.
+	WaitTimeout time.Duration
+
+	dir     string
+	files   map[string]localFile
+	version int
+	mu      sync.Mutex
+}
+
+// localFile is the state of a file when the directory was last listed.
+type localFile struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// NewLocalSource returns a source which lists image files in dir.
+func NewLocalSource(dir string) *LocalSource {
+	return &LocalSource{
+		PollFreq:    time.Second,
+		WaitTimeout: time.Minute,
+		dir:         dir,
+	}
+}
+
+// List returns the image files that have been added, changed or removed since
+// the cursor was issued. Content hashes are only recomputed for files whose
+// size or modification time have changed.
+func (l *LocalSource) List(ctx context.Context, cursor string) ([]Entry, string, bool, error) {
+	infos, err := l.scan()
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reset := cursor == "" || cursor != strconv.Itoa(l.version)
+	prev := l.files
+	if reset {
+		prev = nil
+	}
+
+	var entries []Entry
+	files := make(map[string]localFile, len(infos))
+	for name, fi := range infos {
+		if err := ctx.Err(); err != nil {
+			return nil, "", false, err
+		}
+		f, ok := prev[name]
+		if !ok || f.size != fi.Size() || !f.modTime.Equal(fi.ModTime()) {
+			hash, err := dropbox.FileContentHash(filepath.Join(l.dir, name))
+			if err != nil {
+				return nil, "", false, err
+			}
+			f = localFile{size: fi.Size(), modTime: fi.ModTime(), hash: hash}
+			entries = append(entries, Entry{
+				Name:           name,
+				Size:           int(f.size),
+				Hash:           f.hash,
+				Modified:       f.modTime,
+				ClientModified: f.modTime,
+			})
+		}
+		files[name] = f
+	}
+
+	for name := range prev {
+		if _, ok := files[name]; !ok {
+			entries = append(entries, Entry{Name: name, Deleted: true})
+		}
+	}
+
+	l.files = files
+	l.version++
+	return entries, strconv.Itoa(l.version), reset, nil
+}
+
+// Open returns the contents of the named file.
+func (l *LocalSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if name != filepath.Base(name) {
+		return nil, fmt.Errorf("local: invalid file name: %s", name)
+	}
+	return os.Open(filepath.Join(l.dir, name))
+}
+
+// OpenRange returns length bytes of the named file, starting at offset.
+func (l *LocalSource) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	if name != filepath.Base(name) {
+		return nil, fmt.Errorf("local: invalid file name: %s", name)
+	}
+	f, err := os.Open(filepath.Join(l.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// Wait polls the directory every PollFreq until the files differ from those
+// returned by the last call to List, WaitTimeout elapses, or ctx is done.
+func (l *LocalSource) Wait(ctx context.Context, cursor string) (bool, time.Duration, error) {
+	deadline := time.Now().Add(l.WaitTimeout)
+	for {
+		infos, err := l.scan()
+		if err != nil {
+			return false, 0, err
+		}
+		if l.changed(cursor, infos) {
+			return true, 0, nil
+		}
+		if time.Now().After(deadline) {
+			return false, 0, nil
+		}
+		select {
+		case <-time.After(l.PollFreq):
+		case <-ctx.Done():
+			return false, 0, ctx.Err()
+		}
+	}
+}
+
+// Ping checks that the directory exists.
+func (l *LocalSource) Ping(ctx context.Context) error {
+	fi, err := os.Stat(l.dir)
+	if err == nil && !fi.IsDir() {
+		err = fmt.Errorf("local: not a directory: %s", l.dir)
+	}
+	return err
+}
+
+// changed returns true if the scanned files differ from the last listing.
+func (l *LocalSource) changed(cursor string, infos map[string]os.FileInfo) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cursor != strconv.Itoa(l.version) || len(infos) != len(l.files) {
+		return true
+	}
+	for name, fi := range infos {
+		f, ok := l.files[name]
+		if !ok || f.size != fi.Size() || !f.modTime.Equal(fi.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan returns the image files in the directory, keyed by name.
+func (l *LocalSource) scan() (map[string]os.FileInfo, error) {
+	fis, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]os.FileInfo)
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && isImageFile(fi.Name()) {
+			infos[fi.Name()] = fi
+		}
+	}
+	return infos, nil
+}
+
+// isImageFile returns true for files that can be decoded by Resize.
+func isImageFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
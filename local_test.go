@@ -0,0 +1,107 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dpup/dbps/internal/dropbox"
+	"github.com/stretchr/testify/assert"
+)
+
+// changes returns "type name" for each event published by the album's loads.
+func changes(a *Album) func() []string {
+	var got []string
+	a.Subscribe(func(e Event) {
+		got = append(got, string(e.Type)+" "+e.Photo.Filename)
+	})
+	return func() []string {
+		out := got
+		got = nil
+		return out
+	}
+}
+
+func TestLocalSource_Load(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+	write("a.jpg", testJPEG(t, 20, 10))
+	write("b.JPEG", testJPEG(t, 20, 10))
+	write("c.png", []byte("png"))
+	write("notes.txt", []byte("not a photo"))
+	write(".d.jpg", testJPEG(t, 20, 10))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub.jpg"), 0755))
+
+	a := NewAlbum(t.Name(), NewLocalSource(dir))
+	a.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	next := changes(a)
+
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"a.jpg", "b.JPEG", "c.png"}, filenames(a))
+	assert.Equal(t, []string{"added a.jpg", "added b.JPEG", "added c.png"}, next())
+
+	// Hashes match those Dropbox would report, so cached files are shared.
+	data := testJPEG(t, 20, 10)
+	want, err := dropbox.ContentHash(bytes.NewReader(data))
+	assert.NoError(t, err)
+	p, _ := a.lookup("a.jpg")
+	assert.Equal(t, want, p.Hash)
+	assert.Equal(t, len(data), p.Size)
+
+	// Nothing has changed.
+	assert.NoError(t, a.Load())
+	assert.Nil(t, next())
+
+	updated := testJPEG(t, 30, 10)
+	write("a.jpg", updated)
+	write("e.gif", []byte("gif"))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "b.JPEG")))
+	write("notes.txt", []byte("still not a photo"))
+	assert.NoError(t, a.Load())
+	assert.Equal(t, []string{"a.jpg", "c.png", "e.gif"}, filenames(a))
+	assert.Equal(t, []string{"updated a.jpg", "removed b.JPEG", "added e.gif"}, next())
+	p, _ = a.lookup("a.jpg")
+	assert.Equal(t, len(updated), p.Size)
+	assert.True(t, p.Hash != want)
+}
+
+func TestLocalSource_List(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("a"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.jpg"), []byte("b"), 0644))
+	l := NewLocalSource(dir)
+	ctx := context.Background()
+
+	entries, cursor, reset, err := l.List(ctx, "")
+	assert.NoError(t, err)
+	assert.True(t, reset)
+	assert.Equal(t, 2, len(entries))
+
+	// Only changed files are listed against the latest cursor.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.jpg"), []byte("bb"), 0644))
+	entries, cursor, reset, err = l.List(ctx, cursor)
+	assert.NoError(t, err)
+	assert.False(t, reset)
+	if assert.Equal(t, 1, len(entries)) {
+		assert.Equal(t, "b.jpg", entries[0].Name)
+		assert.Equal(t, 2, entries[0].Size)
+	}
+
+	// An old cursor gets the full listing again.
+	entries, _, reset, err = l.List(ctx, "1")
+	assert.NoError(t, err)
+	assert.True(t, reset)
+	assert.Equal(t, 2, len(entries))
+
+	_, err = l.Open(ctx, "../a.jpg")
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Logger receives the site's log messages. Fields are alternating keys and
+// values, such as "filename", "a.jpg", "err", err. A *slog.Logger can be used
+// directly.
+type Logger interface {
+	// Debug is used for messages about individual files, such as fetching or
+	// resizing a photo, which can be noisy for large albums.
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// stdLogger writes to the standard log package, with fields formatted as
+// key=value pairs after the message.
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, fields ...interface{}) { log.Print(formatLog(msg, fields)) }
+func (stdLogger) Info(msg string, fields ...interface{})  { log.Print(formatLog(msg, fields)) }
+func (stdLogger) Error(msg string, fields ...interface{}) { log.Print(formatLog(msg, fields)) }
+
+func formatLog(msg string, fields []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(fields); i += 2 {
+		v := fmt.Sprint(fields[i+1])
+		if strings.ContainsAny(v, " \"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %v=%s", fields[i], v)
+	}
+	return b.String()
+}
+
+// quietLogger drops debug messages.
+type quietLogger struct {
+	Logger
+}
+
+func (quietLogger) Debug(msg string, fields ...interface{}) {}
@@ -0,0 +1,221 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Help text and type for each metric, in the order they're written.
+var metricInfo = []struct{ name, kind, help string }{
+	{"dbps_load_duration_seconds", "histogram", "Time taken to load the album from its source, by outcome."},
+	{"dbps_dropbox_request_duration_seconds", "histogram", "Latency of Dropbox API calls, including retries, by endpoint."},
+	{"dbps_dropbox_errors_total", "counter", "Failed Dropbox API calls, by endpoint."},
+	{"dbps_cache_requests_total", "counter", "Requests for originals and thumbnails, those not counted as disk hits or misses were served from memory."},
+	{"dbps_cache_hits_total", "counter", "Requests served from the disk cache after missing in memory."},
+	{"dbps_cache_misses_total", "counter", "Requests that had to be downloaded or resized."},
+	{"dbps_cache_fetched_bytes_total", "counter", "Bytes downloaded or resized on a cache miss."},
+	{"dbps_resize_duration_seconds", "histogram", "Time taken to resize a thumbnail, by small, medium or large size."},
+	{"dbps_http_requests_total", "counter", "HTTP responses, by handler and status code."},
+}
+
+// Upper bounds of the histogram buckets, in seconds.
+var metricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metrics collects counters and histograms, which are written in the
+// Prometheus text exposition format.
+type metrics struct {
+	counters   map[series]float64
+	histograms map[series]*histogram
+	mu         sync.Mutex
+}
+
+// series identifies a metric with a particular set of labels. The labels are
+// already formatted, e.g. `cache="thumbnail"`.
+type series struct {
+	name   string
+	labels string
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative.
+	sum    float64
+	count  uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		counters:   make(map[series]float64),
+		histograms: make(map[series]*histogram),
+	}
+}
+
+// add increments a counter.
+func (m *metrics) add(name, labels string, v float64) {
+	m.mu.Lock()
+	m.counters[series{name, labels}] += v
+	m.mu.Unlock()
+}
+
+// observe records a duration in a histogram.
+func (m *metrics) observe(name, labels string, d time.Duration) {
+	v := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.histograms[series{name, labels}]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(metricBuckets))}
+		m.histograms[series{name, labels}] = h
+	}
+	for i, b := range metricBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// observeDropbox records a call made by the Dropbox client.
+func (m *metrics) observeDropbox(endpoint string, d time.Duration, err error) {
+	l := labels("endpoint", endpoint)
+	m.observe("dbps_dropbox_request_duration_seconds", l, d)
+	if err != nil {
+		m.add("dbps_dropbox_errors_total", l, 1)
+	}
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *metrics) write(w *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, info := range metricInfo {
+		var names []series
+		if info.kind == "histogram" {
+			for s := range m.histograms {
+				if s.name == info.name {
+					names = append(names, s)
+				}
+			}
+		} else {
+			for s := range m.counters {
+				if s.name == info.name {
+					names = append(names, s)
+				}
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Slice(names, func(i, j int) bool { return names[i].labels < names[j].labels })
+
+		fmt.Fprintf(w, "# HELP %s %s\n", info.name, info.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", info.name, info.kind)
+		for _, s := range names {
+			if info.kind != "histogram" {
+				fmt.Fprintf(w, "%s%s %s\n", s.name, braces(s.labels), formatFloat(m.counters[s]))
+				continue
+			}
+			h := m.histograms[s]
+			var n uint64
+			for i, b := range metricBuckets {
+				n += h.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", s.name, braces(join(s.labels, labels("le", formatFloat(b)))), n)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", s.name, braces(join(s.labels, `le="+Inf"`)), h.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", s.name, braces(s.labels), formatFloat(h.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", s.name, braces(s.labels), h.count)
+		}
+	}
+}
+
+// labels formats pairs of label names and values.
+func labels(kv ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(kv[i+1])
+		parts = append(parts, kv[i]+`="`+v+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func braces(l string) string {
+	if l == "" {
+		return ""
+	}
+	return "{" + l + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Writes the album's metrics in the Prometheus text format.
+type metricsHandler struct {
+	album *Album
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	h.album.metrics.write(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// instrument counts the responses written by a handler.
+func instrument(m *metrics, name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		m.add("dbps_http_requests_total", labels("handler", name, "code", strconv.Itoa(sw.status)), 1)
+	})
+}
+
+// statusWriter records the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes through to the underlying writer, for streaming responses.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sizeBucket groups thumbnail sizes for metric labels, as the sizes come from
+// requests and labelling each one would create a series per size.
+func sizeBucket(w, h uint) string {
+	if h > w {
+		w = h
+	}
+	switch {
+	case w <= 200:
+		return "small"
+	case w <= 500:
+		return "medium"
+	}
+	return "large"
+}
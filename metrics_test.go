@@ -0,0 +1,26 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeBucket(t *testing.T) {
+	tests := []struct {
+		w, h uint
+		want string
+	}{
+		{1, 1, "small"},
+		{200, 200, "small"},
+		{100, 201, "medium"},
+		{500, 10, "medium"},
+		{501, 1, "large"},
+		{1000, 1000, "large"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sizeBucket(tt.w, tt.h), tt.w, tt.h)
+	}
+}
@@ -4,7 +4,11 @@ package dbps
 
 import (
 	"fmt"
+	"math/big"
+	"strings"
 	"time"
+
+	"github.com/dpup/dbps/internal/goexif/exif"
 )
 
 // Metadata for the photo.
@@ -14,12 +18,130 @@ type Photo struct {
 	Hash            string    `json:"-"`
 	DropboxModified time.Time `json:"-"`
 	ExifCreated     time.Time
+	Width           int       `json:",omitempty"` // After applying the orientation, if known.
+	Height          int       `json:",omitempty"`
+	Location        *Location `json:",omitempty"`
+
+	// Details from the photo's exif data, when present. Photos whose metadata is
+	// provided by the source, as Dropbox does once it has indexed them, only
+	// have these if Album.AlwaysReadExif is set.
+	Orientation  int     `json:",omitempty"` // 1 to 8, as defined by the exif spec.
+	Make         string  `json:",omitempty"`
+	Model        string  `json:",omitempty"`
+	LensModel    string  `json:",omitempty"`
+	FNumber      float64 `json:",omitempty"`
+	ExposureTime string  `json:",omitempty"` // In seconds, e.g. "1/250".
+	ISO          int     `json:",omitempty"`
+	FocalLength  float64 `json:",omitempty"` // In millimeters.
+	Artist       string  `json:",omitempty"`
+	Copyright    string  `json:",omitempty"`
+}
+
+// Location is where a photo was taken.
+type Location struct {
+	Latitude  float64
+	Longitude float64
 }
 
 func (p *Photo) String() string {
 	return fmt.Sprintf("%s (%s)", p.Filename, p.ExifCreated)
 }
 
+// setExif copies the metadata from the photo's exif data, other than the time
+// it was taken.
+func (p *Photo) setExif(x *exif.Exif) {
+	if lat, long, err := x.LatLong(); err == nil {
+		p.Location = &Location{lat, long}
+	}
+
+	p.Orientation = exifInt(x, exif.Orientation)
+	p.Width, p.Height = exifInt(x, exif.PixelXDimension), exifInt(x, exif.PixelYDimension)
+	if p.Width == 0 || p.Height == 0 {
+		p.Width, p.Height = exifInt(x, exif.ImageWidth), exifInt(x, exif.ImageLength)
+	}
+	p.orientSize()
+	p.ISO = exifInt(x, exif.ISOSpeedRatings)
+
+	p.Make = exifString(x, exif.Make)
+	p.Model = exifString(x, exif.Model)
+	p.LensModel = exifString(x, exif.LensModel)
+	p.Artist = exifString(x, exif.Artist)
+	p.Copyright = exifString(x, exif.Copyright)
+
+	if r := exifRat(x, exif.FNumber); r != nil {
+		p.FNumber, _ = r.Float64()
+	}
+	if r := exifRat(x, exif.FocalLength); r != nil {
+		p.FocalLength, _ = r.Float64()
+	}
+	if r := exifRat(x, exif.ExposureTime); r != nil {
+		p.ExposureTime = r.RatString()
+	}
+}
+
+// setMedia copies metadata provided by the source, which takes precedence over
+// the exif data. The exception is dimensions, which are only used if the exif
+// data doesn't have them, as it's unclear whether sources account for the
+// orientation. They're rotated if the orientation was read, and otherwise used
+// as reported.
+func (p *Photo) setMedia(m *MediaInfo) {
+	if m == nil {
+		return
+	}
+	if p.Width == 0 || p.Height == 0 {
+		p.Width, p.Height = m.Width, m.Height
+		p.orientSize()
+	}
+	if m.Location != nil {
+		p.Location = m.Location
+	}
+	if !m.TimeTaken.IsZero() {
+		p.ExifCreated = m.TimeTaken
+	}
+}
+
+// orientSize swaps the width and height of photos whose orientation rotates
+// them by 90°, so that they describe the photo as it's displayed.
+func (p *Photo) orientSize() {
+	if p.Orientation >= 5 && p.Orientation <= 8 {
+		p.Width, p.Height = p.Height, p.Width
+	}
+}
+
+// exifInt returns the first value of an integer field, or 0 if it's missing.
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil || tag.Count == 0 {
+		return 0
+	}
+	v, _ := tag.Int(0)
+	return v
+}
+
+// exifString returns a text field, or "" if it's missing.
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	v, _ := tag.StringVal()
+	return strings.TrimSpace(strings.TrimRight(v, "\x00"))
+}
+
+// exifRat returns the first value of a rational field, or nil if it's missing
+// or invalid.
+func exifRat(x *exif.Exif, name exif.FieldName) *big.Rat {
+	tag, err := x.Get(name)
+	if err != nil || tag.Count == 0 {
+		return nil
+	}
+	n, d, err := tag.Rat2(0)
+	if err != nil || d == 0 || n < 0 {
+		return nil
+	}
+	return big.NewRat(n, d)
+}
+
 // Array of photos, sortable by the Exif created time.
 type photoList []Photo
 
@@ -0,0 +1,215 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPageSize is the largest limit a client can ask for.
+const maxPageSize = 1000
+
+// photoQuery selects a page of photos, parsed from the DataHandler's query
+// parameters:
+//
+//	sort    created (the default), modified or filename
+//	order   asc or desc, defaults to desc for dates and asc for filenames
+//	after   only photos created at or after this time
+//	before  only photos created before this time
+//	prefix  only filenames starting with this, ignoring case
+//	glob    only filenames matching this pattern, ignoring case
+//	limit   the number of photos to return, up to 1000
+//	cursor  the Next value from the previous page
+//
+// Times are RFC 3339 or dates, e.g. 2015-06-01. Pages are keyed on the last
+// photo returned, rather than an offset, so that they don't shift when photos
+// are added or removed.
+type photoQuery struct {
+	sort   string
+	desc   bool
+	after  time.Time
+	before time.Time
+	prefix string
+	glob   string
+	limit  int
+	cursor *pageCursor
+}
+
+// pageCursor is the position after which the next page starts, along with the
+// ordering it applies to.
+type pageCursor struct {
+	Sort     string `json:"s"`
+	Desc     bool   `json:"d"`
+	Key      string `json:"k"`
+	Filename string `json:"f"`
+}
+
+// photoQueryParams are the query parameters understood by parsePhotoQuery.
+var photoQueryParams = []string{"sort", "order", "after", "before", "prefix", "glob", "limit", "cursor"}
+
+// hasPhotoQuery returns true if v includes any photo query parameters. Others,
+// such as those added to bust caches, are ignored.
+func hasPhotoQuery(v url.Values) bool {
+	for _, p := range photoQueryParams {
+		if _, ok := v[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePhotoQuery(v url.Values) (*photoQuery, error) {
+	q := &photoQuery{sort: "created", desc: true}
+
+	switch s := v.Get("sort"); s {
+	case "", "created":
+	case "modified":
+		q.sort = s
+	case "filename":
+		q.sort, q.desc = s, false
+	default:
+		return nil, fmt.Errorf("invalid sort: %s", s)
+	}
+
+	switch o := v.Get("order"); o {
+	case "":
+	case "asc", "desc":
+		q.desc = o == "desc"
+	default:
+		return nil, fmt.Errorf("invalid order: %s", o)
+	}
+
+	var err error
+	if q.after, err = parseQueryTime(v.Get("after")); err != nil {
+		return nil, fmt.Errorf("invalid after: %s", err)
+	}
+	if q.before, err = parseQueryTime(v.Get("before")); err != nil {
+		return nil, fmt.Errorf("invalid before: %s", err)
+	}
+
+	q.prefix = strings.ToLower(v.Get("prefix"))
+	q.glob = strings.ToLower(v.Get("glob"))
+	if _, err := path.Match(q.glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob: %s", err)
+	}
+
+	if l := v.Get("limit"); l != "" {
+		if q.limit, err = strconv.Atoi(l); err != nil || q.limit < 1 {
+			return nil, fmt.Errorf("invalid limit: %s", l)
+		}
+		if q.limit > maxPageSize {
+			q.limit = maxPageSize
+		}
+	}
+
+	if c := v.Get("cursor"); c != "" {
+		q.cursor = &pageCursor{}
+		data, err := base64.RawURLEncoding.DecodeString(c)
+		if err == nil {
+			err = json.Unmarshal(data, q.cursor)
+		}
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		if q.cursor.Sort != q.sort || q.cursor.Desc != q.desc {
+			return nil, errors.New("cursor is for a different sort order")
+		}
+	}
+
+	return q, nil
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// apply returns the page of photos selected by the query, and the cursor for
+// the next page if there is one.
+func (q *photoQuery) apply(photos []Photo) ([]Photo, string) {
+	var matched []Photo
+	for _, p := range photos {
+		if q.matches(p) {
+			matched = append(matched, p)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return q.less(matched[i], matched[j]) })
+
+	if q.cursor != nil {
+		i := sort.Search(len(matched), func(i int) bool {
+			return q.compare(q.key(matched[i]), matched[i].Filename, q.cursor.Key, q.cursor.Filename) > 0
+		})
+		matched = matched[i:]
+	}
+
+	if q.limit == 0 || len(matched) <= q.limit {
+		return matched, ""
+	}
+
+	page := matched[:q.limit]
+	last := page[len(page)-1]
+	data, _ := json.Marshal(pageCursor{q.sort, q.desc, q.key(last), last.Filename})
+	return page, base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q *photoQuery) matches(p Photo) bool {
+	if !q.after.IsZero() && p.ExifCreated.Before(q.after) {
+		return false
+	}
+	if !q.before.IsZero() && !p.ExifCreated.Before(q.before) {
+		return false
+	}
+	name := strings.ToLower(p.Filename)
+	if !strings.HasPrefix(name, q.prefix) {
+		return false
+	}
+	if q.glob != "" {
+		if ok, _ := path.Match(q.glob, name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the value photos are sorted by, formatted so that keys compare in
+// the same order as the values.
+func (q *photoQuery) key(p Photo) string {
+	switch q.sort {
+	case "modified":
+		return p.DropboxModified.UTC().Format("2006-01-02T15:04:05.000000000")
+	case "filename":
+		return p.Filename
+	}
+	return p.ExifCreated.UTC().Format("2006-01-02T15:04:05.000000000")
+}
+
+func (q *photoQuery) less(a, b Photo) bool {
+	return q.compare(q.key(a), a.Filename, q.key(b), b.Filename) < 0
+}
+
+// compare orders photos by key then filename, reversed for descending queries.
+func (q *photoQuery) compare(keyA, nameA, keyB, nameB string) int {
+	c := strings.Compare(keyA, keyB)
+	if c == 0 {
+		c = strings.Compare(nameA, nameB)
+	}
+	if q.desc {
+		c = -c
+	}
+	return c
+}
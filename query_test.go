@@ -0,0 +1,157 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPhotos returns photos a.jpg to e.jpg, taken a day apart from June 1st.
+func testPhotos() []Photo {
+	var photos []Photo
+	for i, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"} {
+		photos = append(photos, Photo{
+			Filename:    name,
+			ExifCreated: time.Date(2015, 6, 1+i, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	return photos
+}
+
+// queryNames parses the query, applies it to photos and returns the filenames
+// on the page.
+func queryNames(t *testing.T, photos []Photo, query string) ([]string, string) {
+	v, err := url.ParseQuery(query)
+	assert.NoError(t, err)
+	q, err := parsePhotoQuery(v)
+	if !assert.NoError(t, err) {
+		return nil, ""
+	}
+	page, next := q.apply(photos)
+	names := []string{}
+	for _, p := range page {
+		names = append(names, p.Filename)
+	}
+	return names, next
+}
+
+func TestPhotoQuery_cursor(t *testing.T) {
+	photos := testPhotos()
+
+	tests := []struct {
+		query string
+		pages [][]string
+	}{
+		{"limit=2", [][]string{{"e.jpg", "d.jpg"}, {"c.jpg", "b.jpg"}, {"a.jpg"}}},
+		{"limit=2&order=asc", [][]string{{"a.jpg", "b.jpg"}, {"c.jpg", "d.jpg"}, {"e.jpg"}}},
+		{"limit=3&sort=filename", [][]string{{"a.jpg", "b.jpg", "c.jpg"}, {"d.jpg", "e.jpg"}}},
+		{"limit=5", [][]string{{"e.jpg", "d.jpg", "c.jpg", "b.jpg", "a.jpg"}}},
+	}
+	for _, tt := range tests {
+		query := tt.query
+		for i, want := range tt.pages {
+			names, next := queryNames(t, photos, query)
+			assert.Equal(t, want, names, tt.query)
+			if i == len(tt.pages)-1 {
+				assert.Equal(t, "", next, tt.query)
+			} else if assert.True(t, next != "", tt.query) {
+				query = tt.query + "&cursor=" + url.QueryEscape(next)
+			}
+		}
+	}
+
+	// Pages don't shift when an earlier photo is removed.
+	_, next := queryNames(t, photos, "limit=2&order=asc")
+	names, _ := queryNames(t, photos[1:], "limit=2&order=asc&cursor="+next)
+	assert.Equal(t, []string{"c.jpg", "d.jpg"}, names)
+}
+
+func TestPhotoQuery_invalid(t *testing.T) {
+	_, next := queryNames(t, testPhotos(), "limit=2")
+
+	tests := []string{
+		"sort=size",
+		"order=up",
+		"after=yesterday",
+		"limit=0",
+		"glob=[",
+		"cursor=nope",
+		"sort=filename&cursor=" + next,
+		"order=asc&cursor=" + next,
+	}
+	for _, query := range tests {
+		v, _ := url.ParseQuery(query)
+		_, err := parsePhotoQuery(v)
+		assert.Error(t, err, query)
+	}
+
+	v, _ := url.ParseQuery("sort=filename&cursor=" + next)
+	_, err := parsePhotoQuery(v)
+	assert.Equal(t, "cursor is for a different sort order", err.Error())
+}
+
+func TestPhotoQuery_times(t *testing.T) {
+	photos := testPhotos()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// After is inclusive and before is exclusive.
+		{"after=2015-06-02", []string{"e.jpg", "d.jpg", "c.jpg", "b.jpg"}},
+		{"before=2015-06-02", []string{"a.jpg"}},
+		{"after=2015-06-02&before=2015-06-04", []string{"c.jpg", "b.jpg"}},
+		{"after=2015-06-02T00:00:01Z", []string{"e.jpg", "d.jpg", "c.jpg"}},
+		{"before=2015-06-01T23:59:59Z", []string{"a.jpg"}},
+		{"before=2015-06-01T00:00:00Z", []string{}},
+		{"after=2015-06-03T02:00:00%2B02:00", []string{"e.jpg", "d.jpg", "c.jpg"}},
+		{"after=2015-06-04&before=2015-06-02", []string{}},
+	}
+	for _, tt := range tests {
+		names, _ := queryNames(t, photos, tt.query)
+		assert.Equal(t, tt.want, names, tt.query)
+	}
+}
+
+func TestHasPhotoQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", false},
+		{"_=1697400000", false},
+		{"v=2", false},
+		{"limit=10", true},
+		{"v=2&sort=filename", true},
+		{"cursor=", true},
+	}
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		assert.Equal(t, tt.want, hasPhotoQuery(v), tt.query)
+	}
+}
+
+func TestJSONHandler_cacheBusting(t *testing.T) {
+	j := &jsonHandler{album: NewAlbum(t.Name(), &memSource{})}
+
+	w := httptest.NewRecorder()
+	j.ServeHTTP(w, httptest.NewRequest("GET", "/photos.json", nil))
+	etag := w.Header().Get("ETag")
+	cached := j.cache
+
+	// Unknown params get the cached response.
+	w = httptest.NewRecorder()
+	j.ServeHTTP(w, httptest.NewRequest("GET", "/photos.json?_=1697400000", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.True(t, cached == j.cache)
+
+	w = httptest.NewRecorder()
+	j.ServeHTTP(w, httptest.NewRequest("GET", "/photos.json?sort=size", nil))
+	assert.Equal(t, 400, w.Code)
+}
@@ -4,27 +4,41 @@ package dbps
 
 import (
 	"bytes"
+	"context"
 	"image"
+	"image/draw"
 	"image/jpeg"
 
 	_ "image/gif"
 	_ "image/png"
 
+	"github.com/dpup/dbps/internal/goexif/exif"
 	"github.com/dpup/dbps/internal/resize"
 )
 
 var nilBytes = []byte{}
 
+// Resize decodes an image and returns a JPEG of size (w x h), cropping the
+// image to fit. The image is first rotated or flipped upright according to its
+// EXIF orientation, if it has one.
 func Resize(data []byte, w, h uint) ([]byte, error) {
 	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nilBytes, err
 	}
 
-	img = Crop(w, h, Cover(w, h, img))
+	// The image is oriented after it's resized, as that's cheaper. Orientations
+	// which rotate the image by 90° mean it's stored with the width and height
+	// swapped.
+	o := orientation(data)
+	if o >= 5 && o <= 8 {
+		img = Orient(Crop(h, w, Cover(h, w, img)), o)
+	} else {
+		img = Orient(Crop(w, h, Cover(w, h, img)), o)
+	}
 
 	var buf bytes.Buffer
-	err = jpeg.Encode(&buf, img, &jpeg.Options{95})
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
 	if err != nil {
 		return nilBytes, err
 	}
@@ -32,6 +46,88 @@ func Resize(data []byte, w, h uint) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ResizeContext is like Resize but returns early with an error if ctx is done
+// first. The resize itself can't be interrupted so continues in the background.
+func ResizeContext(ctx context.Context, data []byte, w, h uint) ([]byte, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+	c := make(chan result, 1)
+	go func() {
+		data, err := Resize(data, w, h)
+		c <- result{data, err}
+	}()
+	select {
+	case r := <-c:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nilBytes, ctx.Err()
+	}
+}
+
+// orientation returns the EXIF orientation of an image, or 1 if it doesn't have
+// one.
+func orientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	if o := exifInt(x, exif.Orientation); o != 0 {
+		return o
+	}
+	return 1
+}
+
+// Orient transforms an image stored with the given EXIF orientation so that it
+// is upright. Orientations 5 to 8 swap the width and height.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+		b = src.Bounds()
+	}
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// Find the pixel in the stored image which ends up at (x, y).
+			sx, sy := x, y
+			switch orientation {
+			case 2: // Mirrored horizontally.
+				sx = w - 1 - x
+			case 3: // Rotated 180°.
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored vertically.
+				sy = h - 1 - y
+			case 5: // Mirrored along the top-left to bottom-right diagonal.
+				sx, sy = y, x
+			case 6: // Needs rotating 90° clockwise.
+				sx, sy = y, h-1-x
+			case 7: // Mirrored along the top-right to bottom-left diagonal.
+				sx, sy = w-1-y, h-1-x
+			case 8: // Needs rotating 90° counter-clockwise.
+				sx, sy = w-1-y, x
+			}
+			i := src.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+			copy(dst.Pix[dst.PixOffset(x, y):], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
 // Cover resizes an image such that it will cover a space of sie (w x h) with no
 // letter boxing. Resultant image is not cropped, so will overflow the target
 // size unless the aspect ratio exactly matches.
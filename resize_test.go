@@ -0,0 +1,55 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pixels returns the gray value of each pixel, row by row.
+func pixels(img image.Image) [][]uint8 {
+	b := img.Bounds()
+	var rows [][]uint8
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row []uint8
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			row = append(row, uint8(r>>8))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	// Stored as:
+	//   1 2 3
+	//   4 5 6
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	copy(img.Pix, []uint8{1, 2, 3, 4, 5, 6})
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{0, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{1, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{2, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{3, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{4, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{5, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{6, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{7, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+		{8, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, pixels(Orient(img, tt.orientation)), tt.orientation)
+	}
+
+	// Sub-images, such as those returned by Crop, are oriented from their bounds.
+	sub := img.SubImage(image.Rect(1, 0, 3, 2))
+	assert.Equal(t, [][]uint8{{5, 2}, {6, 3}}, pixels(Orient(sub, 6)))
+}
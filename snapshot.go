@@ -0,0 +1,94 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// snapshotVersion is bumped when Photo gains fields that are read from the
+// files, so that older snapshots are discarded and the photos loaded again.
+const snapshotVersion = 1
+
+// snapshot is the album state persisted between restarts.
+type snapshot struct {
+	Version int
+	Name    string
+	Cursor  string
+	Photos  []Photo
+}
+
+// UseSnapshot restores the album from filename, if it exists, and saves the
+// album's photos and cursor back to it after each Load that changes anything.
+// This lets a restarted process serve photos immediately and only sync the
+// changes made while it was down.
+func (a *Album) UseSnapshot(filename string) error {
+	a.mu.Lock()
+	a.snapshot = filename
+	a.mu.Unlock()
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var s snapshot
+	if err := gob.NewDecoder(f).Decode(&s); err != nil {
+		return err
+	}
+	if s.Version != snapshotVersion {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// A cursor for a different album would return the wrong deltas, but the
+	// photos are still useful as they'll be matched against the listing by hash.
+	if s.Name == a.name {
+		a.cursor = s.Cursor
+	}
+	a.photoList = s.Photos
+	a.photoMap = make(map[string]Photo)
+	for _, p := range s.Photos {
+		a.photoMap[p.Filename] = p
+	}
+	a.restored = true
+	a.bumpVersion()
+	return nil
+}
+
+// saveSnapshot writes the current state to the snapshot file, if one is
+// configured. The file is replaced atomically.
+func (a *Album) saveSnapshot() error {
+	a.mu.RLock()
+	filename := a.snapshot
+	s := snapshot{Version: snapshotVersion, Name: a.name, Cursor: a.cursor, Photos: a.photoList}
+	a.mu.RUnlock()
+
+	if filename == "" {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".snapshot-")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(&s)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpup/dbps/internal/dropbox"
+)
+
+// Source provides the files that make up an album.
+type Source interface {
+	// List returns the entries that have changed since the cursor was issued,
+	// along with a new cursor. If the cursor is empty, or no longer valid, then
+	// every entry is returned and reset will be true.
+	List(ctx context.Context, cursor string) (entries []Entry, next string, reset bool, err error)
+
+	// Open returns the contents of the named file. The context applies until the
+	// returned reader is closed.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// Watcher is implemented by sources which can notify the album of changes.
+type Watcher interface {
+	// Wait blocks until there are changes since the cursor was issued, or the
+	// source gives up waiting. The returned duration is how long the caller
+	// should wait before calling again.
+	Wait(ctx context.Context, cursor string) (changed bool, backoff time.Duration, err error)
+}
+
+// RangeOpener is implemented by sources which can read part of a file, allowing
+// metadata to be read without fetching the whole image.
+type RangeOpener interface {
+	// OpenRange returns length bytes of the named file, starting at offset.
+	OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Pinger is implemented by sources which can check that they're reachable.
+type Pinger interface {
+	// Ping returns an error if the source can't currently be used.
+	Ping(ctx context.Context) error
+}
+
+// Entry describes a file in a Source.
+type Entry struct {
+	Name           string
+	Size           int
+	Hash           string
+	Modified       time.Time // When the source last saw the file change.
+	ClientModified time.Time // When the file was last changed by its author.
+	Deleted        bool
+
+	// Media is set if the source has already extracted the photo's metadata,
+	// in which case the file doesn't need to be read.
+	Media *MediaInfo
+}
+
+// MediaInfo is photo metadata provided by a source.
+type MediaInfo struct {
+	// Width and Height are used as reported. Dropbox doesn't say whether its
+	// dimensions account for the exif orientation, so rotated photos may have
+	// them swapped unless Album.AlwaysReadExif is set to read the orientation.
+	Width     int
+	Height    int
+	Location  *Location
+	TimeTaken time.Time
+}
+
+// errNotConnected is returned by a dropboxSource that doesn't have a client yet.
+var errNotConnected = errors.New("dbps: no Dropbox account connected")
+
+// listLimit is how many entries are requested per page when listing a folder.
+const listLimit = 2000
+
+// dropboxSource lists files from a Dropbox folder.
+type dropboxSource struct {
+	folder string
+	limit  uint32
+
+	client *dropbox.Client
+	mu     sync.RWMutex
+}
+
+func newDropboxSource(client *dropbox.Client, folder string) *dropboxSource {
+	return &dropboxSource{client: client, folder: folder, limit: listLimit}
+}
+
+// files returns the client for file operations, or an error if no account has
+// been connected.
+func (d *dropboxSource) files() (*dropbox.Files, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.client == nil {
+		return nil, errNotConnected
+	}
+	return d.client.Files, nil
+}
+
+// setClient replaces the client, e.g. when a new account is connected.
+func (d *dropboxSource) setClient(client *dropbox.Client) {
+	d.mu.Lock()
+	d.client = client
+	d.mu.Unlock()
+}
+
+func (d *dropboxSource) List(ctx context.Context, cursor string) (entries []Entry, next string, reset bool, err error) {
+	files, err := d.files()
+	if err != nil {
+		return
+	}
+
+	var out *dropbox.ListFolderOutput
+	if cursor != "" {
+		out, err = files.ListFolderContinueContext(ctx, &dropbox.ListFolderContinueInput{
+			Cursor: cursor,
+		})
+		if isCursorReset(err) {
+			out, err = nil, nil
+		}
+	}
+
+	if out == nil && err == nil {
+		reset = true
+		out, err = files.ListFolderContext(ctx, &dropbox.ListFolderInput{
+			Path:             d.folder,
+			Limit:            d.limit,
+			IncludeMediaInfo: true,
+		})
+	}
+
+	for err == nil {
+		for _, e := range out.Entries {
+			if e.Tag == "folder" {
+				continue
+			}
+			entries = append(entries, Entry{
+				Name:           path.Base(e.PathLower),
+				Size:           int(e.Size),
+				Hash:           e.ContentHash,
+				Modified:       e.ServerModified,
+				ClientModified: e.ClientModified,
+				Deleted:        e.Tag == "deleted",
+				Media:          mediaInfo(e.MediaInfo),
+			})
+		}
+		next = out.Cursor
+		if !out.HasMore {
+			return
+		}
+		out, err = files.ListFolderContinueContext(ctx, &dropbox.ListFolderContinueInput{
+			Cursor: next,
+		})
+	}
+	return nil, "", false, err
+}
+
+func (d *dropboxSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	files, err := d.files()
+	if err != nil {
+		return nil, err
+	}
+	out, err := files.DownloadContext(ctx, &dropbox.DownloadInput{
+		Path: path.Join(d.folder, name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (d *dropboxSource) OpenRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	files, err := d.files()
+	if err != nil {
+		return nil, err
+	}
+	out, err := files.DownloadContext(ctx, &dropbox.DownloadInput{
+		Path:   path.Join(d.folder, name),
+		Offset: offset,
+		Length: length,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (d *dropboxSource) Wait(ctx context.Context, cursor string) (bool, time.Duration, error) {
+	files, err := d.files()
+	if err != nil {
+		return false, 0, err
+	}
+	out, err := files.ListFolderLongpollContext(ctx, &dropbox.ListFolderLongpollInput{
+		Cursor:  cursor,
+		Timeout: 480,
+	})
+	if isCursorReset(err) {
+		return true, 0, nil
+	} else if err != nil {
+		return false, 0, err
+	}
+	return out.Changes, time.Duration(out.Backoff) * time.Second, nil
+}
+
+func (d *dropboxSource) Ping(ctx context.Context) error {
+	d.mu.RLock()
+	client := d.client
+	d.mu.RUnlock()
+	if client == nil {
+		return errNotConnected
+	}
+	_, err := client.Users.GetCurrentAccountContext(ctx)
+	return err
+}
+
+// mediaInfo converts Dropbox's media_info for a photo, returning nil if it isn't
+// available yet.
+func mediaInfo(m *dropbox.MediaInfo) *MediaInfo {
+	if m == nil || m.Pending || m.Metadata == nil || m.Metadata.Photo == nil {
+		return nil
+	}
+	p := m.Metadata.Photo
+	info := &MediaInfo{TimeTaken: p.TimeTaken}
+	if p.Dimensions != nil {
+		info.Width = int(p.Dimensions.Width)
+		info.Height = int(p.Dimensions.Height)
+	}
+	if p.Location != nil {
+		info.Location = &Location{p.Location.Latitude, p.Location.Longitude}
+	}
+	return info
+}
+
+// isCursorReset returns true if Dropbox rejected a list_folder cursor, in which
+// case the folder needs to be listed again from the start.
+func isCursorReset(err error) bool {
+	e, ok := err.(*dropbox.Error)
+	return ok && strings.HasPrefix(e.Summary, "reset/")
+}
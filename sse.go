@@ -0,0 +1,170 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sseHistory is how many events are kept for clients resuming with
+	// Last-Event-ID. Clients which are further behind are sent a reset event.
+	sseHistory = 1000
+
+	// sseBuffer is how many events can be queued for a client before it's
+	// disconnected, it will then reconnect and resume from the history.
+	sseBuffer = 64
+
+	// ssePing is how often a comment is sent to keep idle connections open.
+	ssePing = 30 * time.Second
+)
+
+// Streams photos that are added, updated or removed as Server-Sent Events. The
+// event type is the EventType and the data is the Photo as JSON, as in the
+// DataHandler. If a client resumes from an event that's no longer available,
+// such as after a restart, it's sent a "reset" event and should fetch the
+// whole album again.
+type eventsHandler struct {
+	epoch   string // Distinguishes IDs from different processes.
+	seq     uint64
+	history []sseEvent
+	clients map[chan sseEvent]bool
+	mu      sync.Mutex
+}
+
+type sseEvent struct {
+	seq  uint64
+	typ  EventType
+	data []byte
+}
+
+func newEventsHandler(album *Album) *eventsHandler {
+	h := &eventsHandler{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		clients: make(map[chan sseEvent]bool),
+	}
+	album.Subscribe(h.add)
+	return h
+}
+
+// add records an album event and sends it to connected clients.
+func (h *eventsHandler) add(e Event) {
+	if e.Type == LoadFailed {
+		return
+	}
+	data, err := json.Marshal(e.Photo)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	se := sseEvent{h.seq, e.Type, data}
+	h.history = append(h.history, se)
+	if len(h.history) > sseHistory {
+		h.history = h.history[len(h.history)-sseHistory:]
+	}
+
+	for ch := range h.clients {
+		select {
+		case ch <- se:
+		default:
+			// Too far behind, the client will resume from the history.
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", 500)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+
+	ch := make(chan sseEvent, sseBuffer)
+	h.mu.Lock()
+	replay, ok := h.since(lastID)
+	h.clients[ch] = true
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		if h.clients[ch] {
+			delete(h.clients, ch)
+			close(ch)
+		}
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: 5000\n\n")
+	if !ok {
+		fmt.Fprintf(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range replay {
+		h.write(w, e)
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(ssePing)
+	defer ping.Stop()
+	for {
+		select {
+		case e, open := <-ch:
+			if !open {
+				return
+			}
+			h.write(w, e)
+		case <-ping.C:
+			fmt.Fprintf(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// since returns the events after lastID, or false if the client has missed
+// events that are no longer available. Callers must hold the lock.
+func (h *eventsHandler) since(lastID string) ([]sseEvent, bool) {
+	if lastID == "" {
+		return nil, true
+	}
+	parts := strings.SplitN(lastID, "-", 2)
+	if len(parts) != 2 || parts[0] != h.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || seq > h.seq {
+		return nil, false
+	}
+	if seq == h.seq {
+		return nil, true
+	}
+	if len(h.history) == 0 || h.history[0].seq > seq+1 {
+		return nil, false
+	}
+	events := h.history[seq+1-h.history[0].seq:]
+	return append([]sseEvent(nil), events...), true
+}
+
+func (h *eventsHandler) write(w http.ResponseWriter, e sseEvent) {
+	fmt.Fprintf(w, "id: %s-%d\nevent: %s\ndata: %s\n\n", h.epoch, e.seq, e.typ, e.data)
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestEventsHandler returns a handler with n events, for photos 1.jpg to
+// n.jpg.
+func newTestEventsHandler(t *testing.T, n int) *eventsHandler {
+	h := newEventsHandler(NewAlbum(t.Name(), &memSource{}))
+	for i := 1; i <= n; i++ {
+		h.add(Event{Type: PhotoAdded, Photo: Photo{Filename: fmt.Sprintf("%d.jpg", i)}})
+	}
+	return h
+}
+
+var sseIDPattern = regexp.MustCompile(`(?m)^id: \w+-(\d+)$`)
+
+// connect makes a request which returns once the replayed events are written,
+// and returns the sequence numbers of the events and whether a reset was sent.
+func connect(h *eventsHandler, lastID string) ([]string, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	if lastID != "" {
+		r.Header.Set("Last-Event-ID", lastID)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var seqs []string
+	for _, m := range sseIDPattern.FindAllStringSubmatch(w.Body.String(), -1) {
+		seqs = append(seqs, m[1])
+	}
+	return seqs, strings.Contains(w.Body.String(), "event: reset\n")
+}
+
+func TestEventsHandler_resume(t *testing.T) {
+	h := newTestEventsHandler(t, 5)
+
+	tests := []struct {
+		name   string
+		lastID string
+		want   []string
+		reset  bool
+	}{
+		{"new client", "", nil, false},
+		{"behind", h.epoch + "-2", []string{"3", "4", "5"}, false},
+		{"from the start", h.epoch + "-0", []string{"1", "2", "3", "4", "5"}, false},
+		{"up to date", h.epoch + "-5", nil, false},
+		{"ahead", h.epoch + "-6", nil, true},
+		{"other epoch", "abc-2", nil, true},
+		{"invalid", "2", nil, true},
+		{"invalid seq", h.epoch + "-x", nil, true},
+	}
+	for _, tt := range tests {
+		seqs, reset := connect(h, tt.lastID)
+		assert.Equal(t, tt.want, seqs, tt.name)
+		assert.Equal(t, tt.reset, reset, tt.name)
+	}
+
+	// Browsers that can't set the header can use a query param.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/events?lastEventId="+h.epoch+"-4", nil).WithContext(ctx))
+	assert.Contains(t, w.Body.String(), "id: "+h.epoch+"-5\nevent: added\ndata: {\"Filename\":\"5.jpg\"")
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "id: "))
+}
+
+func TestEventsHandler_history(t *testing.T) {
+	h := newTestEventsHandler(t, sseHistory+5)
+	assert.Equal(t, sseHistory, len(h.history))
+	assert.Equal(t, uint64(6), h.history[0].seq)
+
+	// Events 1 to 5 have been dropped, so clients that missed them are reset.
+	_, reset := connect(h, h.epoch+"-4")
+	assert.True(t, reset)
+
+	seqs, reset := connect(h, h.epoch+"-5")
+	assert.False(t, reset)
+	assert.Equal(t, sseHistory, len(seqs))
+	assert.Equal(t, "6", seqs[0])
+	assert.Equal(t, fmt.Sprint(sseHistory+5), seqs[len(seqs)-1])
+
+	seqs, _ = connect(h, fmt.Sprintf("%s-%d", h.epoch, sseHistory+2))
+	assert.Equal(t, []string{fmt.Sprint(sseHistory + 3), fmt.Sprint(sseHistory + 4), fmt.Sprint(sseHistory + 5)}, seqs)
+}
+
+func TestEventsHandler_slowClient(t *testing.T) {
+	h := newTestEventsHandler(t, 0)
+	ch := make(chan sseEvent, sseBuffer)
+	h.clients[ch] = true
+
+	for i := 0; i < sseBuffer; i++ {
+		h.add(Event{Type: PhotoAdded, Photo: Photo{Filename: "a.jpg"}})
+	}
+	assert.True(t, h.clients[ch])
+
+	// Once its buffer is full the client is disconnected, to resume later.
+	h.add(Event{Type: PhotoAdded, Photo: Photo{Filename: "a.jpg"}})
+	assert.False(t, h.clients[ch])
+	n := 0
+	for range ch {
+		n++
+	}
+	assert.Equal(t, sseBuffer, n)
+}
+
+func TestEventsHandler_live(t *testing.T) {
+	h := newTestEventsHandler(t, 1)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	res, err := http.Get(s.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	// Wait for the client to be registered before adding the event.
+	r := bufio.NewReader(res.Body)
+	line, _ := r.ReadString('\n')
+	assert.Equal(t, "retry: 5000\n", line)
+	h.add(Event{Type: PhotoRemoved, Photo: Photo{Filename: "gone.jpg"}})
+
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return
+		}
+		if line != "\n" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{
+		"id: " + h.epoch + "-2\n",
+		"event: removed\n",
+		"data: " + `{"Filename":"gone.jpg","Size":0,"ExifCreated":"0001-01-01T00:00:00Z"}` + "\n",
+	}, lines)
+}
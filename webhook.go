@@ -0,0 +1,64 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxWebhookBody limits how much of a notification is read.
+const maxWebhookBody = 1 << 20
+
+// Receives Dropbox webhook notifications and asks the album to refresh. GET
+// requests echo the challenge, as Dropbox does when the webhook is registered,
+// and POSTs must be signed with the app secret.
+type webhookHandler struct {
+	album  *Album
+	secret string
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.secret == "" {
+		http.Error(w, "Dropbox app secret not configured", 404)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		io.WriteString(w, r.URL.Query().Get("challenge"))
+
+	case "POST":
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if !h.verify(r.Header.Get("X-Dropbox-Signature"), body) {
+			http.Error(w, "Invalid signature", 403)
+			return
+		}
+		h.album.Logger.Debug("dbps: webhook received")
+		h.album.Refresh()
+
+	default:
+		http.Error(w, "Method not allowed", 405)
+	}
+}
+
+// verify checks that sig is the hex encoded HMAC-SHA256 of the body.
+func (h *webhookHandler) verify(sig string, body []byte) bool {
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
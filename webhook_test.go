@@ -0,0 +1,129 @@
+// Copyright 2015 Daniel Pupius
+
+package dbps
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sign returns the signature Dropbox would send for body.
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	const body = `{"list_folder": {"accounts": ["dbid:1"]}, "delta": {"users": [1]}}`
+
+	tests := []struct {
+		name    string
+		secret  string
+		method  string
+		url     string
+		body    string
+		sig     string
+		code    int
+		resp    string
+		refresh bool
+	}{
+		{
+			name:    "valid signature",
+			secret:  "secret",
+			method:  "POST",
+			body:    body,
+			sig:     sign("secret", body),
+			code:    200,
+			refresh: true,
+		},
+		{
+			name:   "wrong secret",
+			secret: "secret",
+			method: "POST",
+			body:   body,
+			sig:    sign("other", body),
+			code:   403,
+		},
+		{
+			name:   "not hex",
+			secret: "secret",
+			method: "POST",
+			body:   body,
+			sig:    "not-a-signature",
+			code:   403,
+		},
+		{
+			name:   "missing signature",
+			secret: "secret",
+			method: "POST",
+			body:   body,
+			code:   403,
+		},
+		{
+			name:   "tampered body",
+			secret: "secret",
+			method: "POST",
+			body:   strings.Replace(body, "dbid:1", "dbid:2", 1),
+			sig:    sign("secret", body),
+			code:   403,
+		},
+		{
+			name:   "challenge",
+			secret: "secret",
+			method: "GET",
+			url:    "/webhook?challenge=abc123",
+			code:   200,
+			resp:   "abc123",
+		},
+		{
+			name:   "no secret",
+			method: "POST",
+			body:   body,
+			sig:    sign("", body),
+			code:   404,
+		},
+		{
+			name:   "no secret challenge",
+			method: "GET",
+			url:    "/webhook?challenge=abc123",
+			code:   404,
+		},
+		{
+			name:   "method",
+			secret: "secret",
+			method: "PUT",
+			code:   405,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAlbum(t.Name(), &memSource{})
+			h := &webhookHandler{a, tt.secret}
+
+			url := tt.url
+			if url == "" {
+				url = "/webhook"
+			}
+			r := httptest.NewRequest(tt.method, url, strings.NewReader(tt.body))
+			if tt.sig != "" {
+				r.Header.Set("X-Dropbox-Signature", tt.sig)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.resp != "" {
+				assert.Equal(t, tt.resp, w.Body.String())
+			}
+			assert.Equal(t, tt.refresh, len(a.refresh) == 1)
+		})
+	}
+}